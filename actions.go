@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+const (
+	hookTimeout = 10 * time.Minute
+	// moving the data of a big torrent to another disk takes a while
+	moveTimeout = time.Hour
+	// the output of a hook is reported up to the length
+	hookOutputLength = 500
+)
+
+// hooksDir is the directory of the executables exec actions can run, exec actions are disabled if it's empty
+var hooksDir string
+
+// runCompletionActions applies the actions to the torrents finished downloading
+func runCompletionActions(bot telegramClient, client torrentClient, sub *subscription, s settings.Settings) {
+	for update := range sub.C {
+		var finished transmission.Torrents
+		for _, e := range update.events {
+			if e.kind == "finished" {
+				finished = append(finished, e.torrent)
+			}
+		}
+		if len(finished) == 0 {
+			continue
+		}
+
+		actions, err := s.GetCompletionActions()
+		if err != nil {
+			log.Println("GetCompletionActions failed:", err.Error())
+			continue
+		}
+		if len(actions) == 0 {
+			continue
+		}
+		details, err := client.GetTorrentDetails()
+		if err != nil {
+			log.Println("GetTorrentDetails failed:", err.Error())
+			details = map[int]*torrentDetails{}
+		}
+		for _, t := range finished {
+			var hash string
+			if d, ok := details[t.ID]; ok {
+				hash = d.HashString
+			}
+			// hooks could run for long, the next torrents shouldn't wait
+			go applyCompletionActions(bot, client, actions, t, hash)
+		}
+	}
+}
+
+// applyCompletionActions runs the actions matching the torrent, only the first matching move is applied
+// and the hooks run after it, the results are reported to the users who added the actions
+func applyCompletionActions(bot telegramClient, client torrentClient, actions []settings.CompletionAction, t *transmission.Torrent, hash string) {
+	torrent := *t
+	moved := false
+	for _, kind := range []string{"move", "exec"} {
+		for _, action := range actions {
+			if action.Kind != kind || !completionActionMatches(action, &torrent) {
+				continue
+			}
+
+			var text string
+			switch kind {
+			case "move":
+				if moved {
+					continue
+				}
+				moved = true
+				err := client.SetLocation(torrent.ID, action.Target)
+				if err == nil {
+					// the hooks need the data in place
+					err = waitForMove(client, torrent.ID, action.Target)
+				}
+				if err != nil {
+					text = fmt.Sprintf("<b>action</b> <b>%d</b>: moving <code>%s</code> failed: <code>%s</code>",
+						action.ID, escape(torrent.Name), escape(err.Error()))
+					break
+				}
+				torrent.DownloadDir = action.Target
+				text = fmt.Sprintf("<b>action</b> <b>%d</b>: <code>%s</code> is moved to <code>%s</code>",
+					action.ID, escape(torrent.Name), escape(action.Target))
+			case "exec":
+				output, err := runHook(action.Target, &torrent, hash)
+				status := "exited with status <b>0</b>"
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					status = fmt.Sprintf("exited with status <b>%d</b>", exitErr.ExitCode())
+				} else if err != nil {
+					status = fmt.Sprintf("failed: <code>%s</code>", escape(err.Error()))
+				}
+				text = fmt.Sprintf("<b>action</b> <b>%d</b>: <code>%s</code> for <code>%s</code> %s", action.ID,
+					escape(action.Target), escape(torrent.Name), status)
+				if output != "" {
+					text += fmt.Sprintf("\n<pre>%s</pre>", escape(output))
+				}
+			}
+			send(bot, text, int64(action.UserID), roleNone)
+		}
+	}
+}
+
+// waitForMove waits until the data of the torrent is moved to the directory, the daemon moves it in the background
+func waitForMove(client torrentClient, id int, dir string) error {
+	deadline := time.Now().Add(moveTimeout)
+	for {
+		t, err := client.GetTorrent(id)
+		if err != nil {
+			return err
+		}
+		if t.DownloadDir == dir && !isChecking(t) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the data is not moved in %s", moveTimeout)
+		}
+		time.Sleep(time.Second * interval)
+	}
+}
+
+func completionActionMatches(action settings.CompletionAction, t *transmission.Torrent) bool {
+	if action.Rule == "" {
+		return true
+	}
+	rule, err := parseTorrentRule(action.Rule)
+	if err != nil {
+		log.Printf("[ERROR] Action %d: %s", action.ID, err)
+		return false
+	}
+	return rule.match(t)
+}
+
+// hookPath returns the path of the hook in hooksDir, hooks are referenced by file names only
+func hookPath(name string) (string, error) {
+	if hooksDir == "" {
+		return "", fmt.Errorf("hooks are disabled, start the bot with -hooks-dir")
+	}
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("wrong hook name %q", name)
+	}
+	path := filepath.Join(hooksDir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("%s is not executable", name)
+	}
+	return path, nil
+}
+
+// runHook executes the hook with the torrent details in the environment like transmission's script-torrent-done,
+// it returns the tail of the output
+func runHook(name string, t *transmission.Torrent, hash string) (string, error) {
+	path, err := hookPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	var trackers []string
+	for _, tracker := range t.Trackers {
+		trackers = append(trackers, tracker.Announce)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = hooksDir
+	cmd.Env = append(os.Environ(),
+		"TR_TORRENT_ID="+strconv.Itoa(t.ID),
+		"TR_TORRENT_NAME="+t.Name,
+		"TR_TORRENT_DIR="+t.DownloadDir,
+		"TR_TORRENT_HASH="+hash,
+		"TR_TORRENT_SIZE="+strconv.FormatUint(t.SizeWhenDone, 10),
+		"TR_TORRENT_TRACKERS="+strings.Join(trackers, ","),
+		"TR_TIME_LOCALTIME="+time.Now().Format(time.ANSIC),
+	)
+	output, err := cmd.CombinedOutput()
+
+	text := strings.TrimSpace(string(output))
+	if len(text) > hookOutputLength {
+		// the tail starts at a whole character
+		start := len(text) - hookOutputLength
+		for start < len(text) && !utf8.RuneStart(text[start]) {
+			start++
+		}
+		text = "..." + text[start:]
+	}
+	return text, err
+}
+
+// action manages the post-completion actions: action add move <dir> [rule], action add exec <hook> [rule],
+// action list and action rm <id>
+func action(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	tokens := ud.Tokens()
+	command := "list"
+	if len(tokens) > 0 {
+		command = strings.ToLower(tokens[0])
+		tokens = tokens[1:]
+	}
+
+	switch command {
+	case "list", "ls":
+		listCompletionActions(bot, ud, s)
+	case "add":
+		addCompletionAction(bot, ud, s, tokens)
+	case "rm", "remove", "del":
+		removeCompletionActions(bot, ud, s, tokens)
+	default:
+		send(bot, fmt.Sprintf("<b>action</b>: Unknown argument <code>%s</code>, use add, list or rm", escape(command)), ud.Chat.ID, ud.Role())
+	}
+}
+
+func removeCompletionActions(bot telegramClient, ud messageWrapper, s settings.Settings, ids []string) {
+	if len(ids) == 0 {
+		send(bot, "<b>action rm</b>: needs an action ID", ud.Chat.ID, ud.Role())
+		return
+	}
+	actions, err := s.GetCompletionActions()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>action rm</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	for _, id := range ids {
+		var found *settings.CompletionAction
+		for i := range actions {
+			if strconv.Itoa(actions[i].ID) == id {
+				found = &actions[i]
+			}
+		}
+		if found == nil {
+			send(bot, fmt.Sprintf("<b>action rm</b>: no action <code>%s</code>", escape(id)), ud.Chat.ID, ud.Role())
+			continue
+		}
+		if err := s.DeleteCompletionAction(found.ID); err != nil {
+			send(bot, fmt.Sprintf("<b>action rm</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			continue
+		}
+		send(bot, fmt.Sprintf("<b>action rm</b>: action <b>%d</b> %s is removed", found.ID, describeCompletionAction(*found)), ud.Chat.ID, ud.Role())
+	}
+}
+
+func addCompletionAction(bot telegramClient, ud messageWrapper, s settings.Settings, tokens []string) {
+	if len(tokens) < 2 || len(tokens) > 3 {
+		send(bot, "<b>action add</b>: takes <code>move &lt;dir&gt; [rule]</code> or <code>exec &lt;hook&gt; [rule]</code>", ud.Chat.ID, ud.Role())
+		return
+	}
+
+	a := settings.CompletionAction{Kind: strings.ToLower(tokens[0]), Target: tokens[1], UserID: ud.From.ID,
+		Username: ud.From.UserName, Added: time.Now()}
+	var err error
+	switch a.Kind {
+	case "move":
+		if !filepath.IsAbs(a.Target) {
+			err = fmt.Errorf("the directory must be an absolute path")
+		}
+	case "exec":
+		_, err = hookPath(a.Target)
+	default:
+		err = fmt.Errorf("unknown action %q, use move or exec", a.Kind)
+	}
+	if err == nil && len(tokens) == 3 {
+		a.Rule = tokens[2]
+		_, err = parseTorrentRule(a.Rule)
+	}
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>action add</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	if a.ID, err = s.AddCompletionAction(a); err != nil {
+		send(bot, fmt.Sprintf("<b>action add</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, fmt.Sprintf("<b>action add</b>: <b>%d</b> %s, the results are sent to you", a.ID, describeCompletionAction(a)), ud.Chat.ID, ud.Role())
+}
+
+func describeCompletionAction(a settings.CompletionAction) string {
+	text := fmt.Sprintf("%s <code>%s</code>", a.Kind, escape(a.Target))
+	if a.Rule != "" {
+		return text + fmt.Sprintf(" for <code>%s</code>", escape(a.Rule))
+	}
+	return text + " for all torrents"
+}
+
+func listCompletionActions(bot telegramClient, ud messageWrapper, s settings.Settings) {
+	actions, err := s.GetCompletionActions()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>action</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	if len(actions) == 0 {
+		send(bot, "<b>action</b>: no actions, add one with <code>action add move /downloads/tv name:/S\\d\\dE\\d\\d/</code>", ud.Chat.ID, ud.Role())
+		return
+	}
+	buf := new(bytes.Buffer)
+	for _, a := range actions {
+		buf.WriteString(fmt.Sprintf("<b>%d</b> %s, by %s\n", a.ID, describeCompletionAction(a),
+			escape(ownerName(settings.TorrentOwner{UserID: a.UserID, Username: a.Username}))))
+	}
+	send(bot, buf.String(), ud.Chat.ID, ud.Role())
+}
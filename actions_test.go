@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+type locationClient struct {
+	fakeTorrentClient
+	moved map[int]string
+}
+
+func (c *locationClient) SetLocation(id int, dir string) error {
+	c.moved[id] = dir
+	return nil
+}
+
+func (c *locationClient) GetTorrent(id int) (*transmission.Torrent, error) {
+	return &transmission.Torrent{ID: id, DownloadDir: c.moved[id], Status: transmission.StatusSeeding}, nil
+}
+
+func TestApplyCompletionActions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { hooksDir = old }(hooksDir)
+	hooksDir = dir
+
+	hook := "#!/bin/sh\necho \"$TR_TORRENT_ID $TR_TORRENT_NAME $TR_TORRENT_DIR $TR_TORRENT_HASH\"\nexit 3\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "hook"), []byte(hook), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	actions := []settings.CompletionAction{
+		{ID: 1, Kind: "exec", Target: "hook", UserID: 1},
+		{ID: 2, Kind: "move", Target: "/downloads/tv", Rule: "name:/S\\d\\dE\\d\\d/", UserID: 1},
+		{ID: 3, Kind: "move", Target: "/downloads/other", UserID: 1},
+		{ID: 4, Kind: "exec", Target: "hook", Rule: "dir:/downloads/movies", UserID: 1},
+	}
+	client := &locationClient{moved: map[int]string{}}
+	fake := &fakeTelegramClient{}
+	applyCompletionActions(fake, client, actions, &transmission.Torrent{ID: 5, Name: "Show S01E01", DownloadDir: "/downloads"}, "abc")
+
+	if len(client.moved) != 1 || client.moved[5] != "/downloads/tv" {
+		t.Errorf("Wrong moves: %v", client.moved)
+	}
+	var messages []string
+	for _, c := range fake.sent {
+		if msg, ok := c.(tgbotapi.MessageConfig); ok {
+			messages = append(messages, msg.Text)
+		}
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Wrong messages: %v", messages)
+	}
+	text := messages[1]
+	if !strings.Contains(text, "status <b>3</b>") || !strings.Contains(text, "5 Show S01E01 /downloads/tv abc") {
+		t.Errorf("Wrong hook report: %s", text)
+	}
+}
+
+func TestHookPath(t *testing.T) {
+	defer func(old string) { hooksDir = old }(hooksDir)
+	hooksDir = ""
+	if _, err := hookPath("hook"); err == nil {
+		t.Error("Hooks are allowed without a directory")
+	}
+	hooksDir = os.TempDir()
+	for _, name := range []string{"../hook", "/bin/sh", ".hidden", ""} {
+		if _, err := hookPath(name); err == nil {
+			t.Errorf("Hook %q is allowed", name)
+		}
+	}
+}
+
+func TestRunHookOutputTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { hooksDir = old }(hooksDir)
+	hooksDir = dir
+
+	// the two-byte characters don't fit the limit evenly
+	hook := "#!/bin/sh\necho x" + strings.Repeat("é", hookOutputLength) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "hook"), []byte(hook), 0755); err != nil {
+		t.Fatal(err)
+	}
+	output, err := runHook("hook", &transmission.Torrent{ID: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, "...é") || !utf8.ValidString(output) {
+		t.Fatalf("Wrong output tail: %q", output)
+	}
+}
+
+func TestRemoveCompletionActions(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	id, err := s.AddCompletionAction(settings.CompletionAction{Kind: "move", Target: "/media"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ud := wrapMessage(testMessage(100, "master", &tgbotapi.Chat{ID: 100, Type: "private"}, "action rm 99 "+strconv.Itoa(id)), "ourbot")
+	ud.role = roleAdmin
+	fake := &fakeTelegramClient{}
+	action(fake, nil, ud, s)
+	texts := fake.texts()
+	if len(texts) != 2 || !strings.Contains(texts[0], "no action") || !strings.Contains(texts[1], "is removed") {
+		t.Errorf("Wrong replies: %v", texts)
+	}
+	if actions, err := s.GetCompletionActions(); err != nil || len(actions) != 0 {
+		t.Errorf("The action is not removed: %v, %v", actions, err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	defaultAuditRecords = 20
+	auditReplyLength    = 200
+)
+
+// auditRecorder collects the affected torrents, errors and the reply of a command
+type auditRecorder struct {
+	torrentClient
+
+	mu       sync.Mutex
+	torrents []settings.AuditTorrent
+	errors   []string
+	reply    string
+}
+
+// auditReplies is the telegramClient side of auditRecorder
+type auditReplies struct {
+	telegramClient
+	r *auditRecorder
+}
+
+func (a auditReplies) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if msg, ok := c.(tgbotapi.MessageConfig); ok {
+		a.r.mu.Lock()
+		if a.r.reply == "" {
+			a.r.reply = ellipsisString(plainText(msg.Text), auditReplyLength)
+		}
+		a.r.mu.Unlock()
+	}
+	return a.telegramClient.Send(c)
+}
+
+func (r *auditRecorder) record(id int, name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errors = append(r.errors, err.Error())
+		return
+	}
+	r.torrents = append(r.torrents, settings.AuditTorrent{ID: id, Name: name})
+}
+
+func (r *auditRecorder) recordAll(err error) {
+	r.record(0, "all", err)
+}
+
+func (r *auditRecorder) AddByURL(url string) (transmission.TorrentAdded, error) {
+	added, err := r.torrentClient.AddByURL(url)
+	r.record(added.ID, added.Name, err)
+	return added, err
+}
+
+func (r *auditRecorder) AddTorrent(a addArguments) (transmission.TorrentAdded, error) {
+	added, err := r.torrentClient.AddTorrent(a)
+	r.record(added.ID, added.Name, err)
+	return added, err
+}
+
+func (r *auditRecorder) DeleteTorrent(id int, wd bool) (string, error) {
+	name, err := r.torrentClient.DeleteTorrent(id, wd)
+	r.record(id, name, err)
+	return name, err
+}
+
+func (r *auditRecorder) StopTorrent(id int) (string, error) {
+	status, err := r.torrentClient.StopTorrent(id)
+	r.record(id, "", err)
+	return status, err
+}
+
+func (r *auditRecorder) StartTorrent(id int) (string, error) {
+	status, err := r.torrentClient.StartTorrent(id)
+	r.record(id, "", err)
+	return status, err
+}
+
+func (r *auditRecorder) VerifyTorrent(id int) (string, error) {
+	status, err := r.torrentClient.VerifyTorrent(id)
+	r.record(id, "", err)
+	return status, err
+}
+
+func (r *auditRecorder) StopAll() error {
+	err := r.torrentClient.StopAll()
+	r.recordAll(err)
+	return err
+}
+
+func (r *auditRecorder) StartAll() error {
+	err := r.torrentClient.StartAll()
+	r.recordAll(err)
+	return err
+}
+
+func (r *auditRecorder) VerifyAll() error {
+	err := r.torrentClient.VerifyAll()
+	r.recordAll(err)
+	return err
+}
+
+// runAudited runs the command handler and stores what it did in the audit log
+func runAudited(handler commandHandler, bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	// plain messages without a torrent file are not commands
+	if ud.Command() == "" && ud.Document == nil {
+		handler(bot, client, ud, s)
+		return
+	}
+
+	started := time.Now()
+	r := &auditRecorder{torrentClient: client}
+	handler(auditReplies{bot, r}, r, ud, s)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record := settings.AuditRecord{
+		Time:      started,
+		UserID:    ud.From.ID,
+		Username:  ud.From.UserName,
+		ChatID:    ud.Chat.ID,
+		Command:   ud.Command(),
+		Arguments: ud.Tokens(),
+		Outcome:   "ok",
+		Reply:     r.reply,
+	}
+	if ud.Command() == "" {
+		record.Command = "document"
+		record.Arguments = []string{ud.Document.FileName}
+	}
+	for _, t := range r.torrents {
+		if t.Name == "" {
+			// name of a torrent is not returned by the simple commands
+			if torrent, err := client.GetTorrent(t.ID); err == nil {
+				t.Name = torrent.Name
+			}
+		}
+		record.Torrents = append(record.Torrents, t)
+	}
+	_, required := lookupCommand(ud.Command())
+	if ud.Command() == "" {
+		required = documentRole
+	}
+	if ud.Role() < required {
+		record.Outcome = "forbidden"
+	} else if len(r.errors) > 0 {
+		record.Outcome = "error: " + strings.Join(r.errors, "; ")
+	}
+
+	if err := s.AddAuditRecord(record); err != nil {
+		log.Println("AddAuditRecord failed:", err.Error())
+	}
+}
+
+// audit shows the latest executed commands: audit [n] [user], or sends them all as a JSONL document: audit export [user]
+func audit(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	tokens := ud.Tokens()
+	var export bool
+	if len(tokens) > 0 && strings.ToLower(tokens[0]) == "export" {
+		export = true
+		tokens = tokens[1:]
+	}
+
+	n := defaultAuditRecords
+	if len(tokens) > 0 && !export && !strings.HasPrefix(tokens[0], "@") {
+		num, err := strconv.Atoi(tokens[0])
+		if err != nil || num <= 0 {
+			send(bot, fmt.Sprintf("<b>audit</b>: <code>%s</code> is not a number", escape(tokens[0])), ud.Chat.ID, ud.Role())
+			return
+		}
+		n = num
+		tokens = tokens[1:]
+	}
+
+	var userID int
+	if len(tokens) > 0 {
+		id, err := resolveUserID(tokens[0], s)
+		if err != nil {
+			send(bot, fmt.Sprintf("<b>audit</b>: unknown user <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+			return
+		}
+		userID = id
+	}
+	if export {
+		n = 0
+	}
+
+	records, err := s.GetAuditRecords(n, userID)
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>audit</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	if len(records) == 0 {
+		send(bot, "<b>audit</b>: no records", ud.Chat.ID, ud.Role())
+		return
+	}
+
+	if export {
+		buf := new(bytes.Buffer)
+		enc := json.NewEncoder(buf)
+		// the file is chronological, unlike the listing
+		for i := len(records) - 1; i >= 0; i-- {
+			enc.Encode(records[i])
+		}
+		doc := tgbotapi.NewDocumentUpload(ud.Chat.ID, tgbotapi.FileBytes{Name: "audit.jsonl", Bytes: buf.Bytes()})
+		if _, err := bot.Send(doc); err != nil {
+			send(bot, fmt.Sprintf("<b>audit</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		}
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	for _, record := range records {
+		buf.WriteString(formatAuditRecord(record))
+	}
+	send(bot, buf.String(), ud.Chat.ID, ud.Role())
+}
+
+func formatAuditRecord(record settings.AuditRecord) string {
+	user := strconv.Itoa(record.UserID)
+	if record.Username != "" {
+		user = "@" + record.Username
+	}
+
+	var torrents []string
+	for _, t := range record.Torrents {
+		if t.ID == 0 {
+			torrents = append(torrents, t.Name)
+		} else {
+			torrents = append(torrents, fmt.Sprintf("%d %s", t.ID, ellipsisString(t.Name, 25)))
+		}
+	}
+
+	line := fmt.Sprintf("<i>%s</i> %s: <code>%s</code>", record.Time.Format("Jan _2 15:04:05"), escape(user),
+		escape(strings.TrimSpace(record.Command+" "+strings.Join(record.Arguments, " "))))
+	if len(torrents) > 0 {
+		line += " [" + escape(strings.Join(torrents, ", ")) + "]"
+	}
+	return line + " <b>" + escape(record.Outcome) + "</b>\n"
+}
+
+// resolveUserID takes a user ID or @username of a user who has talked to the bot
+func resolveUserID(user string, s settings.Settings) (int, error) {
+	if id, err := strconv.Atoi(user); err == nil {
+		return id, nil
+	}
+	id, err := s.GetUserID(strings.ToLower(strings.TrimPrefix(user, "@")))
+	return int(id), err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// authorizer resolves roles of the message senders. Users are identified by their telegram
+// user IDs, users added by username get their ID on the first message.
+type authorizer struct {
+	groups map[int64]bool
+	s      settings.Settings
+}
+
+func newAuthorizer(groups string, s settings.Settings) (*authorizer, error) {
+	a := &authorizer{
+		groups: make(map[int64]bool),
+		s:      s,
+	}
+
+	for _, group := range splitList(groups) {
+		id, err := strconv.ParseInt(group, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("wrong group chat ID %s", group)
+		}
+		a.groups[id] = true
+	}
+	return a, nil
+}
+
+// seed grants the role to the users passed as a comma separated list of IDs or usernames
+func (a *authorizer) seed(users string, r role) error {
+	for _, user := range splitList(users) {
+		key := userKey(user)
+		// the ID could be known from the previous runs
+		if id, err := a.s.GetUserID(strings.TrimPrefix(key, "@")); err == nil && id != 0 {
+			key = strconv.FormatInt(id, 10)
+		}
+		if err := a.s.SetUserRole(key, r.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// role returns the role of the sender of the message, group chats must be allowed explicitly
+func (a *authorizer) role(msg *tgbotapi.Message) role {
+	if msg.From == nil || msg.Chat == nil || msg.Chat.IsChannel() {
+		return roleNone
+	}
+	if (msg.Chat.IsGroup() || msg.Chat.IsSuperGroup()) && !a.groups[msg.Chat.ID] {
+		return roleNone
+	}
+
+	if r := a.storedRole(strconv.Itoa(msg.From.ID)); r != roleNone {
+		return r
+	}
+
+	username := strings.ToLower(msg.From.UserName)
+	if username == "" {
+		return roleNone
+	}
+	r := a.storedRole("@" + username)
+	if r == roleNone {
+		return roleNone
+	}
+
+	// now the ID is known, the user is stored by it
+	id := strconv.Itoa(msg.From.ID)
+	if err := a.s.SetUserRole(id, r.String()); err != nil {
+		log.Println("SetUserRole failed:", err.Error())
+		return r
+	}
+	if err := a.s.DeleteUserRole("@" + username); err != nil {
+		log.Println("DeleteUserRole failed:", err.Error())
+	}
+	if err := a.s.SetUserID(username, int64(msg.From.ID)); err != nil {
+		log.Println("SetUserID failed:", err.Error())
+	}
+	return r
+}
+
+func (a *authorizer) storedRole(key string) role {
+	name, err := a.s.GetUserRole(key)
+	if err != nil {
+		log.Println("GetUserRole failed:", err.Error())
+		return roleNone
+	}
+	r, _ := parseRole(name)
+	return r
+}
+
+// userIDs returns IDs of all the users having a role
+func (a *authorizer) userIDs() []int {
+	roles, err := a.s.GetUserRoles()
+	if err != nil {
+		log.Println("GetUserRoles failed:", err.Error())
+		return nil
+	}
+	ids := make([]int, 0, len(roles))
+	for key := range roles {
+		if id, err := strconv.Atoi(key); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// admins returns IDs of the users having the admin role
+func (a *authorizer) admins() []int {
+	var ids []int
+	for _, id := range a.userIDs() {
+		if a.storedRole(strconv.Itoa(id)) == roleAdmin {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// userKey converts a user ID or username to the key users are stored by
+func userKey(user string) string {
+	if _, err := strconv.Atoi(user); err == nil {
+		return user
+	}
+	return "@" + strings.ToLower(strings.TrimPrefix(user, "@"))
+}
+
+func splitList(list string) []string {
+	var result []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
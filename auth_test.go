@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// testSettings opens settings in a temporary directory, the returned function removes it
+func testSettings(t *testing.T) (settings.Settings, func()) {
+	dir, err := ioutil.TempDir("", "transmission-telegram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := settings.GetSettings(path.Join(dir, "settings.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testMessage(userID int, username string, chat *tgbotapi.Chat, text string) *tgbotapi.Message {
+	return &tgbotapi.Message{From: &tgbotapi.User{ID: userID, UserName: username}, Chat: chat, Text: text}
+}
+
+func TestAuthorizer(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+
+	auth, err := newAuthorizer("-42", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.seed("100, @Master", roleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.seed("300", roleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	private := &tgbotapi.Chat{ID: 100, Type: "private"}
+	if auth.role(testMessage(100, "", private, "list")) != roleAdmin {
+		t.Fatal("Master is not authorized by ID")
+	}
+	if auth.role(testMessage(300, "viewer", &tgbotapi.Chat{ID: 300, Type: "private"}, "list")) != roleViewer {
+		t.Fatal("Viewer has wrong role")
+	}
+	if auth.role(testMessage(400, "stranger", &tgbotapi.Chat{ID: 400, Type: "private"}, "list")) != roleNone {
+		t.Fatal("Stranger is authorized")
+	}
+	if auth.role(testMessage(200, "master", &tgbotapi.Chat{ID: 200, Type: "private"}, "list")) != roleAdmin {
+		t.Fatal("Master is not authorized by username")
+	}
+	if id, _ := s.GetUserID("master"); id != 200 {
+		t.Fatal("Username ID is not persisted")
+	}
+	if r, _ := s.GetUserRole("200"); r != "admin" {
+		t.Fatal("Role is not stored by ID")
+	}
+
+	if auth.role(testMessage(100, "", &tgbotapi.Chat{ID: -42, Type: "group"}, "/list")) != roleAdmin {
+		t.Fatal("Master is not authorized in allowed group")
+	}
+	if auth.role(testMessage(100, "", &tgbotapi.Chat{ID: -43, Type: "supergroup"}, "/list")) != roleNone {
+		t.Fatal("Master is authorized in unknown group")
+	}
+}
+
+func TestFindHandlerRoles(t *testing.T) {
+	for _, c := range []struct {
+		command string
+		r       role
+		allowed bool
+	}{
+		{"list", roleViewer, true},
+		{"sort", roleViewer, false},
+		{"sort", roleOperator, true},
+		{"start", roleViewer, false},
+		{"start", roleOperator, true},
+		{"deldata", roleOperator, false},
+		{"deldata", roleAdmin, true},
+		// plain messages like stickers are not forbidden, documents are checked by receiveTorrent
+		{"", roleViewer, true},
+	} {
+		handler := findHandler(c.command, c.r)
+		isForbidden := reflect.ValueOf(handler).Pointer() == reflect.ValueOf(forbidden).Pointer()
+		if isForbidden == c.allowed {
+			t.Fatalf("Wrong permission for %s as %s", c.command, c.r)
+		}
+	}
+}
+
+func TestWrapMessageMention(t *testing.T) {
+	chat := &tgbotapi.Chat{ID: -42, Type: "group"}
+	w := wrapMessage(testMessage(100, "", chat, "/list@OurBot dl"), "ourbot")
+	if w.Command() != "/list" || w.ForAnotherBot() || w.Tokens()[0] != "dl" {
+		t.Fatal("Own mention is not handled")
+	}
+	w = wrapMessage(testMessage(100, "", chat, "/list@otherbot"), "ourbot")
+	if !w.ForAnotherBot() {
+		t.Fatal("Mention of another bot is not detected")
+	}
+}
+
+func TestReceiveTorrentRole(t *testing.T) {
+	chat := &tgbotapi.Chat{ID: 300, Type: "private"}
+	sticker := wrapMessage(testMessage(300, "viewer", chat, ""), "ourbot")
+	sticker.role = roleViewer
+	fake := &fakeTelegramClient{}
+	receiveTorrent(fake, nil, sticker, nil)
+	if len(fake.sent) != 0 {
+		t.Fatal("Viewer is answered to a message without a document")
+	}
+
+	msg := testMessage(300, "viewer", chat, "")
+	msg.Document = &tgbotapi.Document{FileID: "file", FileName: "some.torrent"}
+	document := wrapMessage(msg, "ourbot")
+	document.role = roleViewer
+	receiveTorrent(fake, nil, document, nil)
+	var texts []string
+	for _, c := range fake.sent {
+		if m, ok := c.(tgbotapi.MessageConfig); ok {
+			texts = append(texts, m.Text)
+		}
+	}
+	if len(texts) != 1 || !strings.Contains(texts[0], "not allowed") {
+		t.Fatalf("Viewer's torrent file is not refused: %v", texts)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+const (
+	chartWidth  = 800
+	chartHeight = 400
+	chartLeft   = 100
+	chartRight  = 20
+	chartTop    = 40
+	chartBottom = 40
+	fontScale   = 2
+)
+
+var (
+	chartBackground = color.RGBA{255, 255, 255, 255}
+	chartGrid       = color.RGBA{220, 220, 220, 255}
+	chartText       = color.RGBA{60, 60, 60, 255}
+	chartDown       = color.RGBA{33, 150, 243, 255}
+	chartUp         = color.RGBA{76, 175, 80, 255}
+)
+
+// glyphs is a tiny 3x5 bitmap font, enough for speeds and times
+var glyphs = map[rune][5]string{
+	'0': {"111", "101", "101", "101", "111"},
+	'1': {"010", "110", "010", "010", "111"},
+	'2': {"111", "001", "111", "100", "111"},
+	'3': {"111", "001", "111", "001", "111"},
+	'4': {"101", "101", "111", "001", "001"},
+	'5': {"111", "100", "111", "001", "111"},
+	'6': {"111", "100", "111", "101", "111"},
+	'7': {"111", "001", "001", "001", "001"},
+	'8': {"111", "101", "111", "101", "111"},
+	'9': {"111", "101", "111", "001", "111"},
+	'.': {"000", "000", "000", "000", "010"},
+	':': {"000", "010", "000", "010", "000"},
+	'-': {"000", "000", "111", "000", "000"},
+	'/': {"001", "001", "010", "100", "100"},
+	'k': {"100", "101", "110", "101", "101"},
+	'M': {"101", "111", "111", "101", "101"},
+	'G': {"111", "100", "101", "101", "111"},
+	'T': {"111", "010", "010", "010", "010"},
+	'B': {"110", "101", "110", "101", "110"},
+	's': {"011", "100", "010", "001", "110"},
+	'D': {"110", "101", "101", "101", "110"},
+	'L': {"100", "100", "100", "100", "111"},
+	'U': {"101", "101", "101", "101", "111"},
+	'P': {"111", "101", "111", "100", "100"},
+}
+
+// drawText draws the text with the bitmap font, unknown characters are left blank
+func drawText(img *image.RGBA, x int, y int, text string, c color.Color) {
+	for _, r := range text {
+		glyph := glyphs[r]
+		for row, line := range glyph {
+			for col, pixel := range line {
+				if pixel != '1' {
+					continue
+				}
+				rect := image.Rect(x+col*fontScale, y+row*fontScale, x+(col+1)*fontScale, y+(row+1)*fontScale)
+				draw.Draw(img, rect, &image.Uniform{c}, image.ZP, draw.Src)
+			}
+		}
+		x += 4 * fontScale
+	}
+}
+
+func textWidth(text string) int {
+	return len([]rune(text)) * 4 * fontScale
+}
+
+// drawLine draws a 2px wide line with the Bresenham's algorithm
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx := int(math.Abs(float64(x1 - x0)))
+	dy := -int(math.Abs(float64(y1 - y0)))
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		img.Set(x0, y0+1, c)
+		img.Set(x0+1, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// niceCeil rounds the value up to 1, 2 or 5 multiplied by a power of 10
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1024
+	}
+	p := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*p {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+// renderSpeedChart draws download and upload speeds between from and to as a PNG image.
+// Lines are broken where samples are missing for longer than gap.
+func renderSpeedChart(samples []settings.SpeedSample, from time.Time, to time.Time, gap time.Duration, timeFormat string) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.ZP, draw.Src)
+
+	plotWidth := chartWidth - chartLeft - chartRight
+	plotHeight := chartHeight - chartTop - chartBottom
+	bottom := chartHeight - chartBottom
+
+	var max float64
+	for _, sample := range samples {
+		max = math.Max(max, math.Max(float64(sample.Down), float64(sample.Up)))
+	}
+	max = niceCeil(max)
+
+	// horizontal grid with speed labels
+	for i := 0; i <= 4; i++ {
+		y := bottom - plotHeight*i/4
+		drawLine(img, chartLeft, y, chartWidth-chartRight, y, chartGrid)
+		label := humanize.Bytes(uint64(max*float64(i)/4)) + "/s"
+		drawText(img, chartLeft-textWidth(label)-8, y-5, label, chartText)
+	}
+
+	// time labels
+	span := to.Sub(from)
+	for i := 0; i <= 5; i++ {
+		t := from.Add(span * time.Duration(i) / 5)
+		x := chartLeft + plotWidth*i/5
+		drawLine(img, x, bottom, x, bottom+4, chartText)
+		label := t.Format(timeFormat)
+		drawText(img, x-textWidth(label)/2, bottom+12, label, chartText)
+	}
+
+	// legend
+	draw.Draw(img, image.Rect(chartLeft, 12, chartLeft+14, 26), &image.Uniform{chartDown}, image.ZP, draw.Src)
+	drawText(img, chartLeft+20, 14, "DL", chartText)
+	draw.Draw(img, image.Rect(chartLeft+60, 12, chartLeft+74, 26), &image.Uniform{chartUp}, image.ZP, draw.Src)
+	drawText(img, chartLeft+80, 14, "UP", chartText)
+
+	point := func(t time.Time, v uint64) (int, int) {
+		x := chartLeft + int(float64(plotWidth)*float64(t.Sub(from))/float64(span))
+		y := bottom - int(float64(plotHeight)*float64(v)/max)
+		return x, y
+	}
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		if cur.Time.Sub(prev.Time) > gap {
+			continue
+		}
+		x0, y0 := point(prev.Time, prev.Down)
+		x1, y1 := point(cur.Time, cur.Down)
+		drawLine(img, x0, y0, x1, y1, chartDown)
+		x0, y0 = point(prev.Time, prev.Up)
+		x1, y1 = point(cur.Time, cur.Up)
+		drawLine(img, x0, y0, x1, y1, chartUp)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
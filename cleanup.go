@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+const (
+	cleanupInterval = 10 * time.Minute
+	// the dry run report lists only the first candidates
+	cleanupReportSize = 20
+	day               = 24 * time.Hour
+)
+
+// cleanupCandidate is a torrent a policy removes and the reason
+type cleanupCandidate struct {
+	torrent *transmission.Torrent
+	reason  string
+}
+
+// parseCleanupPolicy parses conditions like ratio:2 seeding:30d idle:14d, a tracker: or dir: rule and data
+func parseCleanupPolicy(tokens []string) (settings.CleanupPolicy, error) {
+	var policy settings.CleanupPolicy
+	days := func(value string) (int, error) {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(value), "d"))
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("wrong number of days %q", value)
+		}
+		return n, nil
+	}
+
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		var err error
+		field := strings.ToLower(strings.SplitN(token, ":", 2)[0])
+		value := strings.TrimPrefix(token[len(field):], ":")
+		switch field {
+		case "data":
+			policy.DeleteData = true
+		case "ratio":
+			policy.Ratio, err = strconv.ParseFloat(value, 64)
+			if err == nil && policy.Ratio <= 0 {
+				err = fmt.Errorf("ratio must be positive")
+			}
+		case "seeding":
+			policy.SeedingDays, err = days(value)
+		case "idle":
+			policy.IdleDays, err = days(value)
+		default:
+			if policy.Scope != "" {
+				return policy, fmt.Errorf("only one scope is allowed")
+			}
+			if _, err = parseTorrentRule(token); err == nil {
+				policy.Scope = token
+			}
+		}
+		if err != nil {
+			return policy, err
+		}
+	}
+	if policy.Ratio == 0 && policy.SeedingDays == 0 && policy.IdleDays == 0 {
+		return policy, fmt.Errorf("needs a condition: ratio:N, seeding:Nd or idle:Nd")
+	}
+	return policy, nil
+}
+
+func describeCleanupPolicy(policy settings.CleanupPolicy) string {
+	var conditions []string
+	if policy.Ratio > 0 {
+		conditions = append(conditions, fmt.Sprintf("ratio %.2f", policy.Ratio))
+	}
+	if policy.SeedingDays > 0 {
+		conditions = append(conditions, fmt.Sprintf("seeding %d days", policy.SeedingDays))
+	}
+	if policy.IdleDays > 0 {
+		conditions = append(conditions, fmt.Sprintf("idle %d days", policy.IdleDays))
+	}
+	text := strings.Join(conditions, " or ")
+
+	scope := "all torrents"
+	if policy.Scope != "" {
+		scope = "<code>" + escape(policy.Scope) + "</code>"
+	}
+	text += " for " + scope
+	if policy.DeleteData {
+		text += ", <b>with data</b>"
+	}
+	if !policy.Enabled {
+		text += " <i>(disabled)</i>"
+	}
+	return text
+}
+
+// cleanupCandidates returns the finished torrents the policy removes, kept torrents are skipped
+func cleanupCandidates(policy settings.CleanupPolicy, torrents transmission.Torrents, details map[int]*torrentDetails,
+	kept map[string]string, now time.Time) ([]cleanupCandidate, error) {
+	var scope *torrentRule
+	if policy.Scope != "" {
+		rule, err := parseTorrentRule(policy.Scope)
+		if err != nil {
+			return nil, err
+		}
+		scope = &rule
+	}
+
+	var candidates []cleanupCandidate
+	for _, t := range torrents {
+		d, ok := details[t.ID]
+		if !ok || t.PercentDone < 1 || t.Error != 0 {
+			continue
+		}
+		if _, ok := kept[d.HashString]; ok {
+			continue
+		}
+		if scope != nil && !scope.match(t) {
+			continue
+		}
+
+		// the idle time of torrents without transfers is counted since they finished
+		activity := d.ActivityDate
+		if activity == 0 {
+			activity = d.DoneDate
+		}
+		idle := now.Sub(time.Unix(activity, 0))
+		seeding := time.Duration(d.SecondsSeeding) * time.Second
+
+		var reason string
+		switch {
+		case policy.Ratio > 0 && t.UploadRatio >= policy.Ratio:
+			reason = fmt.Sprintf("ratio %.2f", t.UploadRatio)
+		case policy.SeedingDays > 0 && seeding >= time.Duration(policy.SeedingDays)*day:
+			reason = fmt.Sprintf("seeded %d days", int(seeding/day))
+		case policy.IdleDays > 0 && activity > 0 && idle >= time.Duration(policy.IdleDays)*day:
+			reason = fmt.Sprintf("idle %d days", int(idle/day))
+		default:
+			continue
+		}
+		candidates = append(candidates, cleanupCandidate{t, reason})
+	}
+	return candidates, nil
+}
+
+// cleanupState fetches everything the policies are checked against
+func cleanupState(client torrentClient, s settings.Settings) (transmission.Torrents, map[int]*torrentDetails, map[string]string, error) {
+	torrents, err := client.GetTorrents()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	details, err := client.GetTorrentDetails()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	kept, err := s.GetKeptTorrents()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return torrents, details, kept, nil
+}
+
+// cleaner applies the cleanup policies, a manual run waits for the scheduled one and vice versa
+type cleaner struct {
+	// auth finds the admins notified of the removals
+	auth *authorizer
+	mu   sync.Mutex
+}
+
+// torrentCleaner is shared by the scheduled runs and the cleanup command, it's set by main
+var torrentCleaner *cleaner
+
+// runEvery applies the enabled policies every cleanupInterval
+func (c *cleaner) runEvery(bot telegramClient, client torrentClient, s settings.Settings) {
+	for {
+		time.Sleep(cleanupInterval)
+		c.run(bot, client, s)
+	}
+}
+
+// run removes the torrents matching the enabled policies and notifies the admins of each removal
+func (c *cleaner) run(bot telegramClient, client torrentClient, s settings.Settings) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	policies, err := s.GetCleanupPolicies()
+	if err != nil {
+		log.Println("GetCleanupPolicies failed:", err.Error())
+		return 0
+	}
+	var enabled []settings.CleanupPolicy
+	for _, policy := range policies {
+		if policy.Enabled {
+			enabled = append(enabled, policy)
+		}
+	}
+	if len(enabled) == 0 {
+		return 0
+	}
+
+	torrents, details, kept, err := cleanupState(client, s)
+	if err != nil {
+		log.Printf("[ERROR] Cleanup: %s", err)
+		return 0
+	}
+	pruneKeptTorrents(s, details, kept)
+
+	removed := make(map[int]bool)
+	for _, policy := range enabled {
+		candidates, err := cleanupCandidates(policy, torrents, details, kept, time.Now())
+		if err != nil {
+			log.Printf("[ERROR] Cleanup policy %d: %s", policy.ID, err)
+			continue
+		}
+		for _, candidate := range candidates {
+			if removed[candidate.torrent.ID] {
+				continue
+			}
+			removed[candidate.torrent.ID] = true
+
+			text := fmt.Sprintf("<b>cleanup</b>: policy <b>%d</b> removed <code>%s</code>, %s", policy.ID, escape(candidate.torrent.Name), candidate.reason)
+			if _, err := client.DeleteTorrent(candidate.torrent.ID, policy.DeleteData); err != nil {
+				text = fmt.Sprintf("<b>cleanup</b>: policy <b>%d</b> failed to remove <code>%s</code>: <code>%s</code>",
+					policy.ID, escape(candidate.torrent.Name), escape(err.Error()))
+			} else if policy.DeleteData {
+				text += fmt.Sprintf(", %s of data deleted", humanize.Bytes(candidate.torrent.SizeWhenDone))
+			}
+			for _, id := range c.auth.admins() {
+				send(bot, text, int64(id), roleNone)
+			}
+		}
+	}
+	return len(removed)
+}
+
+// pruneKeptTorrents forgets the kept torrents removed from transmission
+func pruneKeptTorrents(s settings.Settings, details map[int]*torrentDetails, kept map[string]string) {
+	existing := make(map[string]bool, len(details))
+	for _, d := range details {
+		existing[d.HashString] = true
+	}
+	for hash := range kept {
+		if existing[hash] {
+			continue
+		}
+		if err := s.DeleteKeptTorrent(hash); err != nil {
+			log.Println("DeleteKeptTorrent failed:", err.Error())
+		}
+	}
+}
+
+// cleanupCommand manages the cleanup policies: cleanup add ratio:2 seeding:30d idle:14d [tracker:host|dir:path] [data],
+// cleanup list, cleanup dry|enable|disable|rm <id>, cleanup keep|unkeep <ids> and cleanup run
+func cleanupCommand(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	tokens := ud.Tokens()
+	command := "list"
+	if len(tokens) > 0 {
+		command = strings.ToLower(tokens[0])
+		tokens = tokens[1:]
+	}
+
+	switch command {
+	case "list", "ls":
+		listCleanupPolicies(bot, ud, s)
+	case "add":
+		policy, err := parseCleanupPolicy(tokens)
+		if err != nil {
+			send(bot, fmt.Sprintf("<b>cleanup add</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			return
+		}
+		policy.UserID, policy.Username, policy.Added = ud.From.ID, ud.From.UserName, time.Now()
+		if policy.ID, err = s.AddCleanupPolicy(policy); err != nil {
+			send(bot, fmt.Sprintf("<b>cleanup add</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			return
+		}
+		send(bot, fmt.Sprintf("<b>cleanup add</b>: policy <b>%d</b> is added disabled, check what it removes and "+
+			"enable it with <code>cleanup enable %d</code>", policy.ID, policy.ID), ud.Chat.ID, ud.Role())
+		cleanupReport(bot, client, ud, s, policy)
+	case "dry", "enable", "disable", "rm", "remove", "del":
+		if len(tokens) == 0 {
+			send(bot, fmt.Sprintf("<b>cleanup %s</b>: needs a policy ID", command), ud.Chat.ID, ud.Role())
+			return
+		}
+		policy, ok := findCleanupPolicy(bot, ud, s, tokens[0])
+		if !ok {
+			return
+		}
+		switch command {
+		case "dry":
+			cleanupReport(bot, client, ud, s, policy)
+			return
+		case "enable", "disable":
+			policy.Enabled = command == "enable"
+			err := s.UpdateCleanupPolicy(policy)
+			if err == nil {
+				send(bot, fmt.Sprintf("<b>cleanup %s</b>: <b>%d</b> %s", command, policy.ID, describeCleanupPolicy(policy)), ud.Chat.ID, ud.Role())
+				return
+			}
+			send(bot, fmt.Sprintf("<b>cleanup %s</b>: <code>%s</code>", command, escape(err.Error())), ud.Chat.ID, ud.Role())
+		default:
+			if err := s.DeleteCleanupPolicy(policy.ID); err != nil {
+				send(bot, fmt.Sprintf("<b>cleanup rm</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+				return
+			}
+			send(bot, fmt.Sprintf("<b>cleanup rm</b>: policy <b>%d</b> is removed", policy.ID), ud.Chat.ID, ud.Role())
+		}
+	case "keep", "unkeep":
+		keepTorrents(bot, client, ud, s, command == "keep", tokens)
+	case "run":
+		send(bot, fmt.Sprintf("<b>cleanup run</b>: %d torrents are removed", torrentCleaner.run(bot, client, s)), ud.Chat.ID, ud.Role())
+	default:
+		send(bot, fmt.Sprintf("<b>cleanup</b>: Unknown argument <code>%s</code>, use add, list, dry, enable, disable, rm, keep, unkeep or run",
+			escape(command)), ud.Chat.ID, ud.Role())
+	}
+}
+
+func listCleanupPolicies(bot telegramClient, ud messageWrapper, s settings.Settings) {
+	policies, err := s.GetCleanupPolicies()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>cleanup</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	kept, err := s.GetKeptTorrents()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>cleanup</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if len(policies) == 0 {
+		buf.WriteString("No policies, add one with <code>cleanup add ratio:2 idle:30d</code>\n")
+	}
+	for _, policy := range policies {
+		buf.WriteString(fmt.Sprintf("<b>%d</b> %s\n", policy.ID, describeCleanupPolicy(policy)))
+	}
+	if len(kept) > 0 {
+		names := make([]string, 0, len(kept))
+		for _, name := range kept {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		buf.WriteString("\n<b>Kept</b>:\n")
+		for _, name := range names {
+			buf.WriteString(fmt.Sprintf("<code>%s</code>\n", escape(name)))
+		}
+	}
+	send(bot, buf.String(), ud.Chat.ID, ud.Role())
+}
+
+// keepTorrents exempts the torrents from the cleanup policies or returns them back
+func keepTorrents(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings, keep bool, ids []string) {
+	command := "cleanup unkeep"
+	if keep {
+		command = "cleanup keep"
+	}
+	if len(ids) == 0 {
+		send(bot, fmt.Sprintf("<b>%s</b>: needs torrent IDs", command), ud.Chat.ID, ud.Role())
+		return
+	}
+	details, err := client.GetTorrentDetails()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>%s</b>: <code>%s</code>", command, escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	for _, id := range ids {
+		num, err := strconv.Atoi(id)
+		if err != nil {
+			send(bot, fmt.Sprintf("<b>%s</b>: <code>%s</code> is not an ID", command, escape(id)), ud.Chat.ID, ud.Role())
+			continue
+		}
+		torrent, err := client.GetTorrent(num)
+		d, ok := details[num]
+		if err != nil || !ok {
+			send(bot, fmt.Sprintf("<b>%s</b>: No torrent with an ID of %d", command, num), ud.Chat.ID, ud.Role())
+			continue
+		}
+
+		if keep {
+			err = s.SetKeptTorrent(d.HashString, torrent.Name)
+		} else {
+			err = s.DeleteKeptTorrent(d.HashString)
+		}
+		if err != nil {
+			send(bot, fmt.Sprintf("<b>%s</b>: <code>%s</code>", command, escape(err.Error())), ud.Chat.ID, ud.Role())
+			continue
+		}
+		send(bot, fmt.Sprintf("<b>%s</b>: <code>%s</code>", command, escape(torrent.Name)), ud.Chat.ID, ud.Role())
+	}
+}
+
+func findCleanupPolicy(bot telegramClient, ud messageWrapper, s settings.Settings, id string) (settings.CleanupPolicy, bool) {
+	policies, err := s.GetCleanupPolicies()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>cleanup</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return settings.CleanupPolicy{}, false
+	}
+	for _, policy := range policies {
+		if strconv.Itoa(policy.ID) == id {
+			return policy, true
+		}
+	}
+	send(bot, fmt.Sprintf("<b>cleanup</b>: no policy <code>%s</code>", escape(id)), ud.Chat.ID, ud.Role())
+	return settings.CleanupPolicy{}, false
+}
+
+// cleanupReport sends the torrents the policy would remove now
+func cleanupReport(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings, policy settings.CleanupPolicy) {
+	torrents, details, kept, err := cleanupState(client, s)
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>cleanup</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	candidates, err := cleanupCandidates(policy, torrents, details, kept, time.Now())
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>cleanup</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(fmt.Sprintf("<b>Dry run</b> of policy <b>%d</b>: %s\n", policy.ID, describeCleanupPolicy(policy)))
+	if len(candidates) == 0 {
+		buf.WriteString("Nothing would be removed now\n")
+	}
+	var size uint64
+	for i, c := range candidates {
+		size += c.torrent.SizeWhenDone
+		if i < cleanupReportSize {
+			buf.WriteString(fmt.Sprintf("<b>%d</b> <code>%s</code> %s, %s\n", c.torrent.ID, escape(ellipsisString(c.torrent.Name, 40)),
+				humanize.Bytes(c.torrent.SizeWhenDone), c.reason))
+		}
+	}
+	if len(candidates) > cleanupReportSize {
+		buf.WriteString(fmt.Sprintf("<i>and %d more</i>\n", len(candidates)-cleanupReportSize))
+	}
+	if len(candidates) > 0 {
+		freed := "no data is deleted"
+		if policy.DeleteData {
+			freed = humanize.Bytes(size) + " would be freed"
+		}
+		buf.WriteString(fmt.Sprintf("\n%d torrents would be removed, %s. <code>cleanup keep ID</code> exempts a torrent.", len(candidates), freed))
+	}
+	send(bot, buf.String(), ud.Chat.ID, ud.Role())
+}
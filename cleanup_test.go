@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+func TestParseCleanupPolicy(t *testing.T) {
+	policy, err := parseCleanupPolicy([]string{"ratio:1.5", "seeding:30d", "idle:14", "dir:/downloads/tv", "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := settings.CleanupPolicy{Ratio: 1.5, SeedingDays: 30, IdleDays: 14, Scope: "dir:/downloads/tv", DeleteData: true}
+	if policy != expected {
+		t.Errorf("Wrong policy: %+v", policy)
+	}
+
+	for _, tokens := range [][]string{{"data"}, {"ratio:0"}, {"idle:soon"}, {"ratio:2", "size:1"}, {"ratio:2", "dir:/a", "dir:/b"}} {
+		if _, err := parseCleanupPolicy(tokens); err == nil {
+			t.Errorf("%v is parsed", tokens)
+		}
+	}
+}
+
+func TestCleanupCandidates(t *testing.T) {
+	now := time.Date(2020, time.March, 4, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) int64 { return now.Add(-time.Duration(days) * day).Unix() }
+
+	torrents := transmission.Torrents{
+		{ID: 1, Name: "ratio", PercentDone: 1, UploadRatio: 2.5, DownloadDir: "/downloads/tv"},
+		{ID: 2, Name: "seeded", PercentDone: 1, DownloadDir: "/downloads/tv"},
+		{ID: 3, Name: "idle", PercentDone: 1, DownloadDir: "/downloads/tv"},
+		{ID: 4, Name: "downloading", PercentDone: 0.5, UploadRatio: 3, DownloadDir: "/downloads/tv"},
+		{ID: 5, Name: "kept", PercentDone: 1, UploadRatio: 3, DownloadDir: "/downloads/tv"},
+		{ID: 6, Name: "other", PercentDone: 1, UploadRatio: 3, DownloadDir: "/downloads/movies"},
+		{ID: 7, Name: "fresh", PercentDone: 1, UploadRatio: 0.5, DownloadDir: "/downloads/tv"},
+	}
+	details := map[int]*torrentDetails{
+		1: {HashString: "a", ActivityDate: daysAgo(0)},
+		2: {HashString: "b", ActivityDate: daysAgo(0), SecondsSeeding: int64(40 * day / time.Second)},
+		3: {HashString: "c", DoneDate: daysAgo(20)},
+		4: {HashString: "d"},
+		5: {HashString: "e", ActivityDate: daysAgo(0)},
+		6: {HashString: "f", ActivityDate: daysAgo(0)},
+		7: {HashString: "g", ActivityDate: daysAgo(1), DoneDate: daysAgo(2)},
+	}
+	kept := map[string]string{"e": "kept"}
+	policy := settings.CleanupPolicy{Ratio: 2, SeedingDays: 30, IdleDays: 14, Scope: "dir:/downloads/tv"}
+
+	candidates, err := cleanupCandidates(policy, torrents, details, kept, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ratio 2.50", "seeded 40 days", "idle 20 days"}
+	if len(candidates) != len(expected) {
+		t.Fatalf("Wrong candidates: %+v", candidates)
+	}
+	for i, c := range candidates {
+		if c.torrent.ID != i+1 || c.reason != expected[i] {
+			t.Errorf("Wrong candidate %d: %s %s", i, c.torrent.Name, c.reason)
+		}
+	}
+}
+
+func TestCleanerRun(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	auth, err := newAuthorizer("", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.seed("100", roleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	s.AddCleanupPolicy(settings.CleanupPolicy{Ratio: 1.5, Enabled: true})
+
+	client := &quotaClient{fakeTorrentClient: fakeTorrentClient{
+		torrents: transmission.Torrents{
+			{ID: 1, Name: "seeded", PercentDone: 1, UploadRatio: 2},
+			{ID: 2, Name: "fresh", PercentDone: 1, UploadRatio: 1},
+		},
+		hashes: map[int]string{1: "aaa", 2: "bbb"},
+	}}
+	fake := &fakeTelegramClient{}
+	c := &cleaner{auth: auth}
+	if removed := c.run(fake, client, s); removed != 1 || len(client.deleted) != 1 || client.deleted[0] != 1 {
+		t.Fatalf("Wrong torrents are removed: %v", client.deleted)
+	}
+	var admins []int64
+	for _, sent := range fake.sent {
+		if msg, ok := sent.(tgbotapi.MessageConfig); ok {
+			admins = append(admins, msg.ChatID)
+		}
+	}
+	if len(admins) != 1 || admins[0] != 100 {
+		t.Fatalf("Wrong users are notified: %v", admins)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/pyed/transmission"
 	"github.com/zhulik/transmission-telegram/settings"
 	"gopkg.in/telegram-bot-api.v4"
 )
@@ -25,11 +26,18 @@ var (
 	}
 )
 
+// documentRole is the role required to add a torrent by sending its file
+const documentRole = roleOperator
+
 // receiveTorrent gets an update that potentially has a .torrent file to add
 func receiveTorrent(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	if ud.Document.FileID == "" {
+	if ud.Document == nil || ud.Document.FileID == "" {
 		return // has no document
 	}
+	if ud.Role() < documentRole {
+		send(bot, fmt.Sprintf("<b>add</b>: not allowed for the <i>%s</i> role", ud.Role()), ud.Chat.ID, ud.Role())
+		return
+	}
 
 	// get the file ID and make the config
 	fconfig := tgbotapi.FileConfig{
@@ -37,50 +45,50 @@ func receiveTorrent(bot telegramClient, client torrentClient, ud messageWrapper,
 	}
 	file, err := bot.GetFile(fconfig)
 	if err != nil {
-		send(bot, fmt.Sprintf("*ERROR*: `%s`", err.Error()), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>ERROR</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
 		return
 	}
 
 	// add by file URL
-	addTorrentsByURL(bot, client, ud, []string{file.Link(bot.Token())})
+	addTorrentsByURL(bot, client, ud, s, []string{file.Link(bot.Token())})
 }
 
 // stop takes id[s] of torrent[s] or 'all' to stop them
 func mainCommand(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
 	// make sure that we got at least one argument
 	if len(ud.Tokens()) == 0 {
-		send(bot, fmt.Sprintf("*%s*: needs an argument", ud.Command()), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>%s</b>: needs an argument", ud.Command()), ud.Chat.ID, ud.Role())
 		return
 	}
 
 	// if the first argument is 'all' then stop all torrents
 	if ud.Tokens()[0] == "all" {
 		if err := invokeError(client, mainCommands[fmt.Sprintf("%s all", ud.Command())]); err != nil {
-			send(bot, fmt.Sprintf("*%s*: error occurred", ud.Command()), ud.Chat.ID, true)
+			send(bot, fmt.Sprintf("<b>%s</b>: error occurred", ud.Command()), ud.Chat.ID, ud.Role())
 			return
 		}
-		send(bot, fmt.Sprintf("*%s*: ok", ud.Command()), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>%s</b>: ok", ud.Command()), ud.Chat.ID, ud.Role())
 		return
 	}
 
 	for _, id := range ud.Tokens() {
 		num, err := strconv.Atoi(id)
 		if err != nil {
-			send(bot, fmt.Sprintf("*%s*: `%s` is not a number", ud.Command(), id), ud.Chat.ID, true)
+			send(bot, fmt.Sprintf("<b>%s</b>: <code>%s</code> is not a number", ud.Command(), escape(id)), ud.Chat.ID, ud.Role())
 			continue
 		}
 		status, err := invokeStatus(client, mainCommands[ud.Command()], num)
 		if err != nil {
-			send(bot, fmt.Sprintf("*%s*: `%s`", ud.Command(), err.Error()), ud.Chat.ID, true)
+			send(bot, fmt.Sprintf("<b>%s</b>: <code>%s</code>", ud.Command(), escape(err.Error())), ud.Chat.ID, ud.Role())
 			continue
 		}
 
 		torrent, err := client.GetTorrent(num)
 		if err != nil {
-			send(bot, fmt.Sprintf("*[fail] %s*: No torrent with an ID of %d", ud.Command(), num), ud.Chat.ID, true)
+			send(bot, fmt.Sprintf("<b>[fail] %s</b>: No torrent with an ID of %d", ud.Command(), num), ud.Chat.ID, ud.Role())
 			return
 		}
-		send(bot, fmt.Sprintf("*[%s] %s*: `%s`", status, ud.Command(), torrent.Name), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>[%s] %s</b>: <code>%s</code>", escape(status), ud.Command(), escape(torrent.Name)), ud.Chat.ID, ud.Role())
 	}
 }
 
@@ -88,7 +96,7 @@ func mainCommand(bot telegramClient, client torrentClient, ud messageWrapper, s
 func delCommand(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
 	// make sure that we got an argument
 	if len(ud.Tokens()) == 0 {
-		send(bot, fmt.Sprintf("*%s*: needs an ID", ud.Command()), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>%s</b>: needs an ID", ud.Command()), ud.Chat.ID, ud.Role())
 		return
 	}
 
@@ -96,71 +104,120 @@ func delCommand(bot telegramClient, client torrentClient, ud messageWrapper, s s
 	for _, id := range ud.Tokens() {
 		num, err := strconv.Atoi(id)
 		if err != nil {
-			send(bot, fmt.Sprintf("*%s*: `%s` is not an ID", ud.Command(), id), ud.Chat.ID, true)
+			send(bot, fmt.Sprintf("<b>%s</b>: <code>%s</code> is not an ID", ud.Command(), escape(id)), ud.Chat.ID, ud.Role())
 			return
 		}
 
 		name, err := client.DeleteTorrent(num, delParams[ud.Command()])
 		if err != nil {
-			send(bot, fmt.Sprintf("*%s*: `%s`", ud.Command(), err.Error()), ud.Chat.ID, true)
+			send(bot, fmt.Sprintf("<b>%s</b>: <code>%s</code>", ud.Command(), escape(err.Error())), ud.Chat.ID, ud.Role())
 			return
 		}
 
-		send(bot, fmt.Sprintf("*%s*: `%s`", ud.Command(), name), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>%s</b>: <code>%s</code>", ud.Command(), escape(name)), ud.Chat.ID, ud.Role())
 	}
 }
 
 // version sends transmission version + transmission-telegram version
 func version(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	send(bot, fmt.Sprintf("Transmission *%s*\nTransmission-telegram *%s*", client.Version(), VERSION), ud.Chat.ID, true)
+	send(bot, fmt.Sprintf("Transmission <b>%s</b>\nTransmission-telegram <b>%s</b>", escape(client.Version()), VERSION), ud.Chat.ID, ud.Role())
 }
 
 // addTorrentsByURL adds torrent files or magnet links passed by rls
-func addTorrentsByURL(bot telegramClient, client torrentClient, ud messageWrapper, urls []string) {
+func addTorrentsByURL(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings, urls []string) {
 	if len(urls) == 0 {
-		send(bot, "*add*: needs atleast one URL", ud.Chat.ID, true)
+		send(bot, "<b>add</b>: needs atleast one URL", ud.Chat.ID, ud.Role())
 		return
 	}
 
 	// loop over the URL/s and add them
 	for _, url := range urls {
-		torrent, err := client.AddByURL(url)
+		torrent, err := addForUser(client, s, ud.From.ID, ud.From.UserName, addArguments{url: url}, func(text string) {
+			send(bot, "<b>add</b>: "+text, ud.Chat.ID, ud.Role())
+		})
 		if err != nil {
-			send(bot, fmt.Sprintf("*add*: `%s`", err.Error()), ud.Chat.ID, true)
+			send(bot, "<b>add</b>: "+describeAddError(client, err), ud.Chat.ID, ud.Role())
 			continue
 		}
+		send(bot, fmt.Sprintf("<b>add</b>: <b>%d</b> <code>%s</code>", torrent.ID, escape(torrent.Name)), ud.Chat.ID, ud.Role())
+	}
+}
 
-		// check if torrent.Name is empty, then an error happened
-		if torrent.Name == "" {
-			send(bot, fmt.Sprintf("*add*: error adding `%s`", url), ud.Chat.ID, true)
-			continue
+// addForUser adds the torrent on behalf of the user: a torrent already added is returned with a duplicateError,
+// the quota of the user is applied and the user is recorded as the owner, report tells the user
+// about the quota checks finished later
+func addForUser(client torrentClient, s settings.Settings, userID int, username string, a addArguments,
+	report func(text string)) (transmission.TorrentAdded, error) {
+	var t *transmission.Torrent
+	if a.metainfo != nil {
+		t = findExistingMetainfo(client, a.metainfo)
+	} else {
+		t, a.metainfo = findExistingTorrent(client, a.url)
+	}
+	if t != nil {
+		existing := transmission.TorrentAdded{ID: t.ID, Name: t.Name}
+		return existing, &duplicateError{existing}
+	}
+
+	q, err := s.GetUserQuota(strconv.Itoa(userID))
+	if err != nil {
+		return transmission.TorrentAdded{}, err
+	}
+	var torrent transmission.TorrentAdded
+	if q.Unlimited() {
+		torrent, err = client.AddTorrent(a)
+	} else {
+		torrent, err = addWithQuota(client, s, userID, username, q, a, report)
+	}
+	if err != nil {
+		return torrent, err
+	}
+
+	// check if torrent.Name is empty, then an error happened
+	if torrent.Name == "" {
+		return torrent, fmt.Errorf("error adding %s", a.url)
+	}
+	recordOwner(s, torrent, userID, username)
+	return torrent, nil
+}
+
+// describeAddError describes the error of addForUser, duplicates are described with their status
+func describeAddError(client torrentClient, err error) string {
+	if dup, ok := err.(*duplicateError); ok {
+		if t, err := client.GetTorrent(dup.torrent.ID); err == nil {
+			return describeDuplicate(t)
 		}
-		send(bot, fmt.Sprintf("*add*: *%d* `%s`", torrent.ID, torrent.Name), ud.Chat.ID, true)
 	}
+	return fmt.Sprintf("<code>%s</code>", escape(err.Error()))
 }
 
 // add takes an URL to a .torrent file in message to add it to transmission
 func add(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	addTorrentsByURL(bot, client, ud, ud.Tokens())
+	addTorrentsByURL(bot, client, ud, s, ud.Tokens())
 }
 
 // help sends help messsage
 func help(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	send(bot, HELP, ud.Chat.ID, true)
+	send(bot, HELP, ud.Chat.ID, ud.Role())
+}
+
+// forbidden sends message that the user's role doesn't allow the command
+func forbidden(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	send(bot, fmt.Sprintf("<b>%s</b>: not allowed for the <i>%s</i> role", escape(ud.Command()), ud.Role()), ud.Chat.ID, ud.Role())
 }
 
 // unknownCommand sends message that command is unknown
 func unknownCommand(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	send(bot, "no such command, try /help", ud.Chat.ID, true)
+	send(bot, "no such command, try /help", ud.Chat.ID, ud.Role())
 }
 
 // sort changes torrents sorting
 func sortCommand(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
 	if len(ud.Tokens()) == 0 {
 		send(bot, `sort takes one of:
-			(*id, name, age, size, progress, downspeed, upspeed, download, upload, ratio*)
-			optionally start with (*rev*) for reversed order
-			e.g. "*sort rev size*" to get biggest torrents first.`, ud.Chat.ID, true)
+			(<b>id, name, age, size, progress, downspeed, upspeed, download, upload, ratio</b>)
+			optionally start with (<b>rev</b>) for reversed order
+			e.g. "<b>sort rev size</b>" to get biggest torrents first.`, ud.Chat.ID, ud.Role())
 		return
 	}
 
@@ -175,8 +232,8 @@ func sortCommand(bot telegramClient, client torrentClient, ud messageWrapper, s
 
 	if mode, ok := sortingMethods[mode]; ok {
 		client.SetSort(mode)
-		send(bot, fmt.Sprintf("*sort*: `%s` reversed: %t", tokens[0], reversed), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>sort</b>: <code>%s</code> reversed: %t", escape(tokens[0]), reversed), ud.Chat.ID, ud.Role())
 	} else {
-		send(bot, "*sort*: unkown sorting method", ud.Chat.ID, true)
+		send(bot, "<b>sort</b>: unkown sorting method", ud.Chat.ID, ud.Role())
 	}
 }
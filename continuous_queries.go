@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -13,122 +15,119 @@ import (
 )
 
 const (
-	duration               = 60
 	interval time.Duration = 2
 )
 
 // info takes an id of a torrent and returns some info about it
 func info(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
 	if len(ud.Tokens()) == 0 {
-		send(bot, "*info*: needs a torrent ID number", ud.Chat.ID, true)
+		send(bot, "<b>info</b>: needs a torrent ID number", ud.Chat.ID, ud.Role())
 		return
 	}
 
+	owners, err := torrentOwners(client, s)
+	if err != nil {
+		log.Println("Getting torrent owners failed:", err.Error())
+	}
+
+	var ids []int
+	names := make(map[int]string)
 	for _, id := range ud.Tokens() {
 		torrentID, err := strconv.Atoi(id)
 		if err != nil {
-			send(bot, fmt.Sprintf("*info*: %s is not a number", id), ud.Chat.ID, true)
+			send(bot, fmt.Sprintf("<b>info</b>: %s is not a number", escape(id)), ud.Chat.ID, ud.Role())
 			continue
 		}
 
 		_, err = client.GetTorrent(torrentID)
 		if err != nil {
-			send(bot, fmt.Sprintf("*info*: Can't find a torrent with an ID of %d", torrentID), ud.Chat.ID, true)
+			send(bot, fmt.Sprintf("<b>info</b>: Can't find a torrent with an ID of %d", torrentID), ud.Chat.ID, ud.Role())
 			continue
 		}
-		go updateTorrentInfo(bot, client, ud, torrentID)
+		if o, ok := owners[torrentID]; ok {
+			names[torrentID] = ownerName(o)
+		}
+		ids = append(ids, torrentID)
+	}
+	if len(ids) > 0 {
+		go updateTorrentInfo(bot, client, ud, ids, names)
 	}
 }
 
-func updateTorrentInfo(bot telegramClient, client torrentClient, ud messageWrapper, torrentID int) {
-	msgID := -1
-	for i := 0; i < duration; i++ {
-		torrent, err := client.GetTorrent(torrentID)
-		if err != nil {
-			continue // skip this iteration if there's an error retrieving the torrent's info
-		}
-
-		info := fmt.Sprintf("*%d* `%s`\n%s *%s* of *%s* (*%.1f%%*) ↓ *%s*  ↑ *%s* R: *%s*\nDL: *%s* UP: *%s*\nAdded: *%s*, ETA: *%s*",
-			torrent.ID, torrent.Name, torrent.TorrentStatus(), humanize.Bytes(torrent.Have()), humanize.Bytes(torrent.SizeWhenDone),
-			torrent.PercentDone*100, humanize.Bytes(torrent.RateDownload), humanize.Bytes(torrent.RateUpload), torrent.Ratio(),
-			humanize.Bytes(torrent.DownloadedEver), humanize.Bytes(torrent.UploadedEver), time.Unix(torrent.AddedDate, 0).Format(time.Stamp),
-			torrent.ETA())
+// updateTorrentInfo shows the info of the torrents in one live view, so a new info takes over the message of the previous one
+func updateTorrentInfo(bot telegramClient, client torrentClient, ud messageWrapper, ids []int, owners map[int]string) {
+	runLiveView(bot, ud.Chat.ID, "info", func(torrents transmission.Torrents) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+		var infos []string
+		for _, torrentID := range ids {
+			torrent := findTorrent(torrents, torrentID)
+			if torrent == nil {
+				// the torrent could be added after the poll
+				var err error
+				if torrent, err = client.GetTorrent(torrentID); err != nil {
+					return "", nil, fmt.Errorf("<b>info</b>: Can't find a torrent with an ID of %d", torrentID)
+				}
+			}
 
-		// update the message
-		if msgID == -1 {
-			msgID = sendWithKeyboard(bot, info, ud.Chat.ID, torrentKeyboard(torrentID))
-		} else {
-			editConf := tgbotapi.NewEditMessageText(ud.Chat.ID, msgID, info)
-			editConf.ParseMode = tgbotapi.ModeMarkdown
-			editConf.ReplyMarkup = torrentKeyboard(torrentID)
-			bot.Send(editConf)
+			info := fmt.Sprintf("<b>%d</b> <code>%s</code>\n%s <b>%s</b> of <b>%s</b> (<b>%.1f%%</b>) ↓ <b>%s</b>  ↑ <b>%s</b> R: <b>%s</b>\nDL: <b>%s</b> UP: <b>%s</b>\nAdded: <b>%s</b>, ETA: <b>%s</b>",
+				torrent.ID, escape(torrent.Name), torrent.TorrentStatus(), humanize.Bytes(torrent.Have()), humanize.Bytes(torrent.SizeWhenDone),
+				torrent.PercentDone*100, humanize.Bytes(torrent.RateDownload), humanize.Bytes(torrent.RateUpload), torrent.Ratio(),
+				humanize.Bytes(torrent.DownloadedEver), humanize.Bytes(torrent.UploadedEver), time.Unix(torrent.AddedDate, 0).Format(time.Stamp),
+				torrent.ETA())
+			if owner := owners[torrentID]; owner != "" {
+				info += fmt.Sprintf("\nAdded by: <b>%s</b>", escape(owner))
+			}
+			infos = append(infos, info)
 		}
-		time.Sleep(time.Second * interval)
-	}
+		if len(ids) > 1 {
+			// the buttons are for a single torrent
+			return strings.Join(infos, "\n\n"), nil, nil
+		}
+		return infos[0], torrentKeyboard(ids[0], ud.Role()), nil
+	}, nil)
 }
 
 // speed will echo back the current download and upload speeds
 func speed(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	// keep track of the returned message ID from 'send()' to edit the message.
-	msgID := -1
-	for i := 0; i < duration; i++ {
-		stats, err := client.GetStats()
-		if err != nil {
-			send(bot, fmt.Sprintf("*speed*: `%s`", err.Error()), ud.Chat.ID, true)
-			return
-		}
-
-		msg := fmt.Sprintf("↓ *%s*  ↑ *%s*", humanize.Bytes(stats.DownloadSpeed), humanize.Bytes(stats.UploadSpeed))
-
-		// if we haven't send a message, send it and save the message ID to edit it the next iteration
-		if msgID == -1 {
-			msgID = send(bot, msg, ud.Chat.ID, false)
-			time.Sleep(time.Second * interval)
-			continue
-		}
-
-		// we have sent the message, let's update.
-		editConf := tgbotapi.NewEditMessageText(ud.Chat.ID, msgID, msg)
-		bot.Send(editConf)
-		editConf.ParseMode = tgbotapi.ModeMarkdown
-		time.Sleep(time.Second * interval)
-	}
-
-	editConf := tgbotapi.NewEditMessageText(ud.Chat.ID, msgID, "↓ - B  ↑ - B")
-	editConf.ParseMode = tgbotapi.ModeMarkdown
-	bot.Send(editConf)
+	runLiveView(bot, ud.Chat.ID, "speed", func(torrents transmission.Torrents) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+		down, up := totalSpeed(torrents)
+		return fmt.Sprintf("↓ <b>%s</b>  ↑ <b>%s</b>", humanize.Bytes(down), humanize.Bytes(up)), nil, nil
+	}, func(string) string {
+		return "↓ - B  ↑ - B"
+	})
 }
 
 // progress echo bach progress and other info for downloading torrents
 func progress(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	msgID := -1
-	for i := 0; i < duration; i++ {
-		torrents, err := client.GetTorrents()
-		if err != nil {
-			send(bot, "Torrents obtain error: "+err.Error(), ud.Chat.ID, true)
-			continue
-		}
-
+	runLiveView(bot, ud.Chat.ID, "progress", func(torrents transmission.Torrents) (string, *tgbotapi.InlineKeyboardMarkup, error) {
 		buf := new(bytes.Buffer)
 		for _, t := range torrents {
 			if t.Status == transmission.StatusDownloading {
-				buf.WriteString(fmt.Sprintf("*%d* `%s`\n%s %.1f%% %s ↓%s\n", t.ID, ellipsisString(t.Name, 30), progressString(t.PercentDone, 10), t.PercentDone*100, t.ETA(), humanize.Bytes(t.RateDownload)))
+				buf.WriteString(fmt.Sprintf("<b>%d</b> <code>%s</code>\n%s %.1f%% %s ↓%s\n", t.ID, escape(ellipsisString(t.Name, 30)), progressString(t.PercentDone, 10), t.PercentDone*100, t.ETA(), humanize.Bytes(t.RateDownload)))
 			}
 		}
 
 		if buf.Len() == 0 {
-			send(bot, "No torrents", ud.Chat.ID, true)
-			return
+			return "", nil, fmt.Errorf("No torrents")
 		}
+		return buf.String(), nil, nil
+	}, nil)
+}
 
-		if msgID == -1 {
-			msgID = send(bot, buf.String(), ud.Chat.ID, false)
-			continue
+// findTorrent returns the torrent with the ID or nil
+func findTorrent(torrents transmission.Torrents, id int) *transmission.Torrent {
+	for _, t := range torrents {
+		if t.ID == id {
+			return t
 		}
+	}
+	return nil
+}
 
-		editConf := tgbotapi.NewEditMessageText(ud.Chat.ID, msgID, buf.String())
-		editConf.ParseMode = tgbotapi.ModeMarkdown
-		bot.Send(editConf)
-		time.Sleep(time.Second * interval)
+// totalSpeed returns the sum of download and upload rates of the torrents
+func totalSpeed(torrents transmission.Torrents) (down uint64, up uint64) {
+	for _, t := range torrents {
+		down += t.RateDownload
+		up += t.RateUpload
 	}
+	return
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// the dashboard is updated faster while something is downloading
+	dashboardActiveInterval = 5 * time.Second
+	dashboardIdleInterval   = time.Minute
+	dashboardDownloads      = 10
+)
+
+// dashboardRegistry keeps one dashboard per chat
+type dashboardRegistry struct {
+	mu      sync.Mutex
+	cancels map[int64]context.CancelFunc
+}
+
+var dashboards = &dashboardRegistry{cancels: make(map[int64]context.CancelFunc)}
+
+func (r *dashboardRegistry) start(chatID int64) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.cancels[chatID]; ok {
+		old()
+	}
+	r.cancels[chatID] = cancel
+	return ctx
+}
+
+func (r *dashboardRegistry) stop(chatID int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancel, ok := r.cancels[chatID]
+	if ok {
+		cancel()
+		delete(r.cancels, chatID)
+	}
+	return ok
+}
+
+// dashboard posts and pins a message with the daemon state which is updated forever, dashboard off stops it
+func dashboard(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	if len(ud.Tokens()) > 0 && strings.ToLower(ud.Tokens()[0]) == "off" {
+		if !dashboards.stop(ud.Chat.ID) {
+			send(bot, "<b>dashboard</b>: there is no dashboard in this chat", ud.Chat.ID, ud.Role())
+			return
+		}
+		if err := s.DeleteDashboard(ud.Chat.ID); err != nil {
+			log.Println("DeleteDashboard failed:", err.Error())
+		}
+		bot.Send(tgbotapi.UnpinChatMessageConfig{ChatID: ud.Chat.ID})
+		send(bot, "<b>dashboard</b>: stopped", ud.Chat.ID, ud.Role())
+		return
+	}
+
+	// the replaced dashboard is deleted, so only the new one is pinned
+	if stored, err := s.GetDashboards(); err != nil {
+		log.Println("GetDashboards failed:", err.Error())
+	} else if old, ok := stored[ud.Chat.ID]; ok {
+		dashboards.stop(ud.Chat.ID)
+		bot.Send(tgbotapi.NewDeleteMessage(ud.Chat.ID, old))
+	}
+
+	msgID := send(bot, "<b>Dashboard</b>: loading...", ud.Chat.ID, roleNone)
+	if msgID == 0 {
+		return
+	}
+	if _, err := bot.Send(tgbotapi.PinChatMessageConfig{ChatID: ud.Chat.ID, MessageID: msgID, DisableNotification: true}); err != nil {
+		log.Printf("[ERROR] Pin: %s", err)
+	}
+	if err := s.SetDashboard(ud.Chat.ID, msgID); err != nil {
+		send(bot, fmt.Sprintf("<b>dashboard</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+	}
+	go runDashboard(dashboards.start(ud.Chat.ID), bot, client, ud.Chat.ID, msgID, s)
+}
+
+// resumeDashboards restarts the dashboards stored before the restart
+func resumeDashboards(bot telegramClient, client torrentClient, s settings.Settings) {
+	stored, err := s.GetDashboards()
+	if err != nil {
+		log.Println("GetDashboards failed:", err.Error())
+		return
+	}
+	for chatID, msgID := range stored {
+		go runDashboard(dashboards.start(chatID), bot, client, chatID, msgID, s)
+	}
+}
+
+func runDashboard(ctx context.Context, bot telegramClient, client torrentClient, chatID int64, msgID int, s settings.Settings) {
+	sub := poller.subscribe()
+	defer poller.unsubscribe(sub)
+
+	var renderedAt time.Time
+	var delay time.Duration
+	for {
+		var update pollUpdate
+		select {
+		case <-ctx.Done():
+			return
+		case update = <-sub.C:
+		}
+		if time.Since(renderedAt) < delay {
+			continue
+		}
+
+		text, active := renderDashboard(client, update.torrents, update.err)
+		if err := edit(bot, text, chatID, msgID, nil); err != nil && strings.Contains(err.Error(), "message to edit not found") {
+			if ctx.Err() != nil {
+				// the dashboard is replaced while it's edited
+				return
+			}
+			// the message is deleted, so is the dashboard
+			log.Printf("[INFO] Dashboard in chat %d is deleted", chatID)
+			dashboards.stop(chatID)
+			s.DeleteDashboard(chatID)
+			return
+		}
+		renderedAt = time.Now()
+
+		delay = dashboardIdleInterval
+		if active {
+			delay = dashboardActiveInterval
+		}
+	}
+}
+
+// renderDashboard returns the dashboard text and whether something is downloading,
+// the error of the poll is shown above the torrents polled before it
+func renderDashboard(client torrentClient, torrents transmission.Torrents, pollErr error) (string, bool) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(fmt.Sprintf("<b>Dashboard</b> <i>%s</i>\n\n", time.Now().Format("Jan _2 15:04:05")))
+	if pollErr != nil {
+		buf.WriteString(fmt.Sprintf("<b>transmission</b>: <code>%s</code>\n\n", escape(pollErr.Error())))
+	}
+
+	down, up := totalSpeed(torrents)
+	buf.WriteString(fmt.Sprintf("↓ <b>%s</b>  ↑ <b>%s</b>\n", humanize.Bytes(down), humanize.Bytes(up)))
+
+	if session, err := client.GetSession(); err != nil {
+		buf.WriteString(fmt.Sprintf("<b>session</b>: <code>%s</code>\n", escape(err.Error())))
+	} else {
+		altSpeed := "off"
+		if session.AltSpeedEnabled {
+			altSpeed = fmt.Sprintf("on (↓ %d kB/s ↑ %d kB/s)", session.AltSpeedDown, session.AltSpeedUp)
+		}
+		buf.WriteString(fmt.Sprintf("Alt speed: <b>%s</b>\n", altSpeed))
+
+		if free, err := client.FreeSpace(session.DownloadDir); err == nil {
+			buf.WriteString(fmt.Sprintf("Free space: <b>%s</b>\n", humanize.Bytes(free)))
+		}
+	}
+
+	buf.WriteString("\n" + countByStatus(torrents) + "\n")
+
+	var downloading int
+	for _, t := range torrents {
+		if t.Status != transmission.StatusDownloading {
+			continue
+		}
+		if downloading == 0 {
+			buf.WriteString("\n")
+		}
+		downloading++
+		if downloading > dashboardDownloads {
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("<b>%d</b> <code>%s</code>\n%s\n", t.ID, escape(ellipsisString(t.Name, 30)), progressBar(t)))
+	}
+	if downloading > dashboardDownloads {
+		buf.WriteString(fmt.Sprintf("<i>and %d more</i>\n", downloading-dashboardDownloads))
+	}
+	return buf.String(), downloading > 0
+}
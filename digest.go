@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+const (
+	digestCheckInterval = time.Minute
+	digestListLength    = 10
+	digestRatioLeaders  = 5
+)
+
+var (
+	clockRegex = regexp.MustCompile(`^(\d{1,2}):(\d{2})$`)
+)
+
+// nextDigest returns the first time the digest is due after the time
+func nextDigest(d settings.Digest, after time.Time) time.Time {
+	next := time.Date(after.Year(), after.Month(), after.Day(), d.Hour, d.Minute, 0, 0, after.Location())
+	for !next.After(after) || (d.Weekly && next.Weekday() != d.Weekday) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func parseClock(clock string) (int, int, bool) {
+	m := clockRegex.FindStringSubmatch(clock)
+	if m == nil {
+		return 0, 0, false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute, _ := strconv.Atoi(m[2])
+	return hour, minute, hour < 24 && minute < 60
+}
+
+func describeDigest(d settings.Digest) string {
+	if d.Weekly {
+		return fmt.Sprintf("weekly on %s at %02d:%02d", d.Weekday, d.Hour, d.Minute)
+	}
+	return fmt.Sprintf("daily at %02d:%02d", d.Hour, d.Minute)
+}
+
+// digest configures the scheduled summary: digest daily 09:00, digest weekly mon 09:00 or digest off
+func digest(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	key := strconv.Itoa(ud.From.ID)
+	tokens := ud.Tokens()
+
+	if len(tokens) == 0 {
+		digests, err := s.GetUserDigests()
+		if err != nil {
+			send(bot, fmt.Sprintf("<b>digest</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			return
+		}
+		if d, ok := digests[key]; ok {
+			send(bot, fmt.Sprintf("<b>digest</b> is sent %s", describeDigest(d)), ud.Chat.ID, ud.Role())
+		} else {
+			send(bot, "<b>digest</b> is disabled", ud.Chat.ID, ud.Role())
+		}
+		return
+	}
+
+	var d settings.Digest
+	switch strings.ToLower(tokens[0]) {
+	case "off", "false", "disable":
+		if err := s.DeleteUserDigest(key); err != nil {
+			send(bot, fmt.Sprintf("<b>digest</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			return
+		}
+		send(bot, "<b>digest</b>: disabled", ud.Chat.ID, ud.Role())
+		return
+	case "daily":
+		tokens = tokens[1:]
+	case "weekly":
+		d.Weekly = true
+		d.Weekday = time.Monday
+		tokens = tokens[1:]
+		if len(tokens) > 0 {
+			if day, ok := parseWeekday(tokens[0]); ok {
+				d.Weekday = day
+				tokens = tokens[1:]
+			}
+		}
+	default:
+		send(bot, fmt.Sprintf("<b>digest</b>: Unknown argument <code>%s</code>, try <code>digest daily 09:00</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	if len(tokens) == 0 {
+		send(bot, "<b>digest</b>: needs a time like <code>09:00</code>", ud.Chat.ID, ud.Role())
+		return
+	}
+	var ok bool
+	if d.Hour, d.Minute, ok = parseClock(tokens[0]); !ok {
+		send(bot, fmt.Sprintf("<b>digest</b>: wrong time <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	// the first digest covers the period since now
+	d.LastSent = time.Now()
+	if stats, err := client.GetStats(); err == nil {
+		d.Downloaded = stats.CumulativeStats.DownloadedBytes
+		d.Uploaded = stats.CumulativeStats.UploadedBytes
+	}
+	if err := s.SetUserDigest(key, d); err != nil {
+		send(bot, fmt.Sprintf("<b>digest</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, fmt.Sprintf("<b>digest</b>: will be sent %s", describeDigest(d)), ud.Chat.ID, ud.Role())
+}
+
+// parseWeekday parses a full or a three-letter day name like monday or mon
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// sendDigests sends the due digests every minute
+func sendDigests(bot telegramClient, client torrentClient, s settings.Settings) {
+	for {
+		time.Sleep(digestCheckInterval)
+
+		digests, err := s.GetUserDigests()
+		if err != nil {
+			log.Println("GetUserDigests failed:", err.Error())
+			continue
+		}
+		now := time.Now()
+		for key, d := range digests {
+			prefs, err := s.GetUserNotifications(key)
+			if err != nil {
+				log.Println("GetUserNotifications failed:", err.Error())
+				continue
+			}
+			// the digest time is in the time zone of the user
+			if nextDigest(d, d.LastSent.In(location(prefs))).After(now) {
+				continue
+			}
+			userID, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			text, stats, err := buildDigest(client, d)
+			if err != nil {
+				log.Println("Digest failed:", err.Error())
+				continue
+			}
+			send(bot, text, userID, roleNone)
+
+			d.LastSent = now
+			d.Downloaded = stats.CumulativeStats.DownloadedBytes
+			d.Uploaded = stats.CumulativeStats.UploadedBytes
+			if err := s.SetUserDigest(key, d); err != nil {
+				log.Println("SetUserDigest failed:", err.Error())
+			}
+		}
+	}
+}
+
+// delta is the difference of cumulative counters, which are reset sometimes
+func delta(now uint64, before uint64) uint64 {
+	if now < before {
+		return now
+	}
+	return now - before
+}
+
+// buildDigest summarises what happened since the previous digest
+func buildDigest(client torrentClient, d settings.Digest) (string, *transmission.Stats, error) {
+	stats, err := client.GetStats()
+	if err != nil {
+		return "", nil, err
+	}
+	torrents, err := client.GetTorrents()
+	if err != nil {
+		return "", nil, err
+	}
+	details, err := client.GetTorrentDetails()
+	if err != nil {
+		return "", nil, err
+	}
+
+	since := d.LastSent.Unix()
+	var added, finished, errored transmission.Torrents
+	var total uint64
+	for _, t := range torrents {
+		total += t.SizeWhenDone
+		if t.AddedDate > since {
+			added = append(added, t)
+		}
+		if detail, ok := details[t.ID]; ok && detail.DoneDate > since {
+			finished = append(finished, t)
+		}
+		if t.Error != 0 {
+			errored = append(errored, t)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(fmt.Sprintf("<b>Digest</b> since <i>%s</i>\n\n", d.LastSent.Format("Jan _2 15:04")))
+	buf.WriteString(fmt.Sprintf("Transferred: ↓ <b>%s</b>  ↑ <b>%s</b>\n",
+		humanize.Bytes(delta(stats.CumulativeStats.DownloadedBytes, d.Downloaded)),
+		humanize.Bytes(delta(stats.CumulativeStats.UploadedBytes, d.Uploaded))))
+	buf.WriteString(fmt.Sprintf("Torrents: <b>%d</b>, <b>%s</b>\n", len(torrents), humanize.Bytes(total)))
+	if session, err := client.GetSession(); err == nil {
+		if free, err := client.FreeSpace(session.DownloadDir); err == nil {
+			buf.WriteString(fmt.Sprintf("Free space: <b>%s</b>\n", humanize.Bytes(free)))
+		}
+	}
+
+	writeDigestList(buf, "Added", added, func(t *transmission.Torrent) string { return "" })
+	writeDigestList(buf, "Finished", finished, func(t *transmission.Torrent) string { return "" })
+	writeDigestList(buf, "Errors", errored, func(t *transmission.Torrent) string {
+		return " <i>" + escape(t.ErrorString) + "</i>"
+	})
+
+	leaders := append(transmission.Torrents{}, torrents...)
+	sort.SliceStable(leaders, func(i, j int) bool { return leaders[i].UploadRatio > leaders[j].UploadRatio })
+	if len(leaders) > digestRatioLeaders {
+		leaders = leaders[:digestRatioLeaders]
+	}
+	writeDigestList(buf, "Ratio leaders", leaders, func(t *transmission.Torrent) string {
+		return " R: <b>" + t.Ratio() + "</b>"
+	})
+
+	return buf.String(), stats, nil
+}
+
+func writeDigestList(buf *bytes.Buffer, title string, torrents transmission.Torrents, suffix func(*transmission.Torrent) string) {
+	if len(torrents) == 0 {
+		return
+	}
+	buf.WriteString(fmt.Sprintf("\n<b>%s</b>: %d\n", title, len(torrents)))
+	for i, t := range torrents {
+		if i == digestListLength {
+			buf.WriteString(fmt.Sprintf("<i>and %d more</i>\n", len(torrents)-digestListLength))
+			break
+		}
+		buf.WriteString(fmt.Sprintf("<b>%d</b> <code>%s</code>%s\n", t.ID, escape(ellipsisString(t.Name, 30)), suffix(t)))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+func TestNextDigest(t *testing.T) {
+	after := time.Date(2020, time.March, 4, 10, 0, 0, 0, time.UTC) // Wednesday
+
+	cases := []struct {
+		digest settings.Digest
+		next   time.Time
+	}{
+		{settings.Digest{Hour: 9}, time.Date(2020, time.March, 5, 9, 0, 0, 0, time.UTC)},
+		{settings.Digest{Hour: 11, Minute: 30}, time.Date(2020, time.March, 4, 11, 30, 0, 0, time.UTC)},
+		{settings.Digest{Hour: 10}, time.Date(2020, time.March, 5, 10, 0, 0, 0, time.UTC)},
+		{settings.Digest{Weekly: true, Weekday: time.Monday, Hour: 9}, time.Date(2020, time.March, 9, 9, 0, 0, 0, time.UTC)},
+		{settings.Digest{Weekly: true, Weekday: time.Wednesday, Hour: 12}, time.Date(2020, time.March, 4, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		if next := nextDigest(c.digest, after); !next.Equal(c.next) {
+			t.Errorf("nextDigest(%+v) = %s, expected %s", c.digest, next, c.next)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	if h, m, ok := parseClock("9:05"); !ok || h != 9 || m != 5 {
+		t.Errorf("Wrong clock: %d %d %v", h, m, ok)
+	}
+	for _, clock := range []string{"24:00", "12:60", "noon", "1200"} {
+		if _, _, ok := parseClock(clock); ok {
+			t.Errorf("%s is accepted", clock)
+		}
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	cases := map[string]time.Weekday{"mon": time.Monday, "Sunday": time.Sunday, "SAT": time.Saturday}
+	for name, day := range cases {
+		if d, ok := parseWeekday(name); !ok || d != day {
+			t.Errorf("parseWeekday(%q) = %s, %v", name, d, ok)
+		}
+	}
+	// the Kelvin sign is shorter lowercased
+	for _, name := range []string{"K", "mo", "mondays", ""} {
+		if _, ok := parseWeekday(name); ok {
+			t.Errorf("%q is a weekday", name)
+		}
+	}
+}
+
+func TestDigestWeeklyWrongDay(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	fake := &fakeTelegramClient{}
+	chat := &tgbotapi.Chat{ID: 1, Type: "private"}
+
+	digest(fake, nil, wrapMessage(testMessage(1, "", chat, "digest weekly K 09:00"), ""), s)
+	digests, err := s.GetUserDigests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 0 {
+		t.Fatalf("Digest is set: %+v", digests)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// telegram allows about 30 messages per second in total
+	globalRate  = 30
+	globalBurst = 30
+	// and about one message per second in a private chat, or 20 messages per minute in a group
+	privateRate = 1
+	groupRate   = 20.0 / 60
+	chatBurst   = 3
+)
+
+// chatIdleTimeout is how long the queue of a chat is kept after its last message, its bucket is full by then
+const chatIdleTimeout = time.Minute
+
+// tokenBucket is a simple rate limiter
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	until  time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until a token is available and takes it
+func (b *tokenBucket) wait() {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		if now.Before(b.until) {
+			delay := b.until.Sub(now)
+			b.mu.Unlock()
+			time.Sleep(delay)
+			continue
+		}
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+// pause stops handing out tokens for the given duration
+func (b *tokenBucket) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.until = time.Now().Add(d)
+	b.tokens = 0
+}
+
+// outgoing is a request waiting in a chat queue
+type outgoing struct {
+	chattable tgbotapi.Chattable
+	done      chan struct{}
+	msg       tgbotapi.Message
+	err       error
+}
+
+type chatQueue struct {
+	chatID  int64
+	bucket  *tokenBucket
+	pending []*outgoing
+	// edits holds pending edits by message ID, so repeated edits of the same message are coalesced
+	edits   map[int]*outgoing
+	running bool
+	// idleSince is when the last message was delivered
+	idleSince time.Time
+}
+
+// dispatcher is a telegramClient which delivers all outgoing messages respecting telegram rate limits.
+// Send blocks until the message is delivered, so callers sending too fast are slowed down.
+type dispatcher struct {
+	client telegramClient
+	global *tokenBucket
+	// idleTimeout is chatIdleTimeout, tests make it shorter
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	chats map[int64]*chatQueue
+}
+
+func newDispatcher(client telegramClient) *dispatcher {
+	return &dispatcher{
+		client:      client,
+		global:      newTokenBucket(globalRate, globalBurst),
+		idleTimeout: chatIdleTimeout,
+		chats:       make(map[int64]*chatQueue),
+	}
+}
+
+func (d *dispatcher) GetFile(c tgbotapi.FileConfig) (tgbotapi.File, error) {
+	return d.client.GetFile(c)
+}
+
+func (d *dispatcher) Token() string {
+	return d.client.Token()
+}
+
+func (d *dispatcher) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	chatID, msgID := target(c)
+	if chatID == 0 {
+		return d.deliver(nil, c)
+	}
+
+	d.mu.Lock()
+	q, ok := d.chats[chatID]
+	if !ok {
+		rate := float64(privateRate)
+		if chatID < 0 {
+			rate = groupRate
+		}
+		q = &chatQueue{chatID: chatID, bucket: newTokenBucket(rate, chatBurst), edits: make(map[int]*outgoing)}
+		d.chats[chatID] = q
+	}
+
+	if _, ok := c.(tgbotapi.ChatActionConfig); ok && q.running {
+		// chat actions are not worth waiting for a busy chat
+		d.mu.Unlock()
+		return tgbotapi.Message{}, nil
+	}
+
+	if o, ok := q.edits[msgID]; ok && msgID != 0 {
+		// the message is still waiting to be edited, just replace the new text
+		o.chattable = c
+		d.mu.Unlock()
+		<-o.done
+		return o.msg, o.err
+	}
+
+	o := &outgoing{chattable: c, done: make(chan struct{})}
+	q.pending = append(q.pending, o)
+	if msgID != 0 {
+		q.edits[msgID] = o
+	}
+	if !q.running {
+		q.running = true
+		go d.drain(q)
+	}
+	d.mu.Unlock()
+
+	<-o.done
+	return o.msg, o.err
+}
+
+// drain delivers queued messages of one chat one by one
+func (d *dispatcher) drain(q *chatQueue) {
+	for {
+		d.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.idleSince = time.Now()
+			d.mu.Unlock()
+			time.AfterFunc(d.idleTimeout, func() { d.prune(q) })
+			return
+		}
+		o := q.pending[0]
+		q.pending = q.pending[1:]
+		for id, e := range q.edits {
+			if e == o {
+				delete(q.edits, id)
+			}
+		}
+		c := o.chattable
+		d.mu.Unlock()
+
+		o.msg, o.err = d.deliver(q.bucket, c)
+		close(o.done)
+	}
+}
+
+// prune drops the queue of the chat if nothing was sent to the chat for idleTimeout
+func (d *dispatcher) prune(q *chatQueue) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if q.running || time.Since(q.idleSince) < d.idleTimeout || d.chats[q.chatID] != q {
+		return
+	}
+	delete(d.chats, q.chatID)
+}
+
+// deliver sends a message retrying it when telegram asks to slow down, the delay applies to
+// all the chats as telegram limits the bot as a whole
+func (d *dispatcher) deliver(bucket *tokenBucket, c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	for {
+		if bucket != nil {
+			bucket.wait()
+		}
+		d.global.wait()
+
+		msg, err := d.client.Send(c)
+		if tgErr, ok := err.(tgbotapi.Error); ok && tgErr.RetryAfter > 0 {
+			delay := time.Duration(tgErr.RetryAfter) * time.Second
+			log.Printf("[INFO] Telegram asked to retry after %s", delay)
+			if bucket != nil {
+				bucket.pause(delay)
+			}
+			d.global.pause(delay)
+			continue
+		}
+		return msg, err
+	}
+}
+
+// target returns the chat and the edited message of a request
+func target(c tgbotapi.Chattable) (chatID int64, msgID int) {
+	switch c := c.(type) {
+	case tgbotapi.MessageConfig:
+		return c.ChatID, 0
+	case tgbotapi.EditMessageTextConfig:
+		return c.ChatID, c.MessageID
+	case tgbotapi.ChatActionConfig:
+		return c.ChatID, 0
+	case tgbotapi.DocumentConfig:
+		return c.ChatID, 0
+	case tgbotapi.PhotoConfig:
+		return c.ChatID, 0
+	case tgbotapi.PinChatMessageConfig:
+		return c.ChatID, 0
+	case tgbotapi.DeleteMessageConfig:
+		return c.ChatID, 0
+	}
+	return 0, 0
+}
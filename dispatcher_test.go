@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+type fakeTelegramClient struct {
+	mu      sync.Mutex
+	sent    []tgbotapi.Chattable
+	block   chan struct{}
+	retries int
+}
+
+func (f *fakeTelegramClient) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.retries > 0 {
+		f.retries--
+		return tgbotapi.Message{}, tgbotapi.Error{Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 1}}
+	}
+	f.sent = append(f.sent, c)
+	return tgbotapi.Message{MessageID: len(f.sent)}, nil
+}
+
+// texts returns the texts of the sent messages
+func (f *fakeTelegramClient) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var texts []string
+	for _, c := range f.sent {
+		if msg, ok := c.(tgbotapi.MessageConfig); ok {
+			texts = append(texts, msg.Text)
+		}
+	}
+	return texts
+}
+
+func (f *fakeTelegramClient) GetFile(tgbotapi.FileConfig) (tgbotapi.File, error) {
+	return tgbotapi.File{}, nil
+}
+
+func (f *fakeTelegramClient) Token() string {
+	return ""
+}
+
+func TestDispatcherCoalescesEdits(t *testing.T) {
+	fake := &fakeTelegramClient{block: make(chan struct{})}
+	d := newDispatcher(fake)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.Send(tgbotapi.NewMessage(1, "first"))
+	}()
+	// wait for the first message to be taken from the queue
+	time.Sleep(50 * time.Millisecond)
+
+	for _, text := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			d.Send(tgbotapi.NewEditMessageText(1, 42, text))
+		}(text)
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(fake.block)
+	wg.Wait()
+
+	if len(fake.sent) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(fake.sent))
+	}
+	if fake.sent[1].(tgbotapi.EditMessageTextConfig).Text != "c" {
+		t.Fatal("The last edit must win")
+	}
+}
+
+func TestDispatcherRetryAfter(t *testing.T) {
+	fake := &fakeTelegramClient{retries: 1}
+	d := newDispatcher(fake)
+
+	start := time.Now()
+	msg, err := d.Send(tgbotapi.NewMessage(1, "text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.MessageID != 1 {
+		t.Fatal("Message is not delivered")
+	}
+	if time.Since(start) < time.Second {
+		t.Fatal("retry_after is not honoured")
+	}
+	// the other chats wait too
+	d.global.mu.Lock()
+	defer d.global.mu.Unlock()
+	if !d.global.until.After(start) {
+		t.Fatal("retry_after doesn't pause the global bucket")
+	}
+}
+
+func TestDispatcherPrunesIdleChats(t *testing.T) {
+	d := newDispatcher(&fakeTelegramClient{})
+	d.idleTimeout = 10 * time.Millisecond
+	if _, err := d.Send(tgbotapi.NewMessage(1, "text")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.chats) != 0 {
+		t.Fatalf("Idle chat queues are kept: %d", len(d.chats))
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pyed/transmission"
+)
+
+const (
+	// maxMetainfoSize limits the size of the .torrent files downloaded to find duplicates
+	maxMetainfoSize = 10 << 20
+	// maxBencodeDepth limits the nesting of lists and dictionaries in a .torrent file
+	maxBencodeDepth = 32
+)
+
+var metainfoClient = &http.Client{Timeout: rssTimeout}
+
+// torrentIdentity is what a torrent is recognized by before it's added, name and size could be unknown for magnets
+type torrentIdentity struct {
+	hash string
+	name string
+	size uint64
+}
+
+// parseMagnet returns the identity of a magnet link, the hash is hex or base32 encoded in xt
+func parseMagnet(link string) (torrentIdentity, error) {
+	var id torrentIdentity
+	u, err := url.Parse(link)
+	if err != nil {
+		return id, err
+	}
+	query := u.Query()
+	for _, xt := range query["xt"] {
+		if !strings.HasPrefix(strings.ToLower(xt), "urn:btih:") {
+			continue
+		}
+		hash := xt[len("urn:btih:"):]
+		switch len(hash) {
+		case 40:
+			if _, err := hex.DecodeString(hash); err == nil {
+				id.hash = strings.ToLower(hash)
+			}
+		case 32:
+			if data, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash)); err == nil {
+				id.hash = hex.EncodeToString(data)
+			}
+		}
+	}
+	if id.hash == "" {
+		return id, fmt.Errorf("no info hash in the magnet link")
+	}
+	id.name = query.Get("dn")
+	id.size, _ = strconv.ParseUint(query.Get("xl"), 10, 64)
+	return id, nil
+}
+
+// parseMetainfo returns the identity of a .torrent file, the hash is SHA-1 of the bencoded info dictionary
+func parseMetainfo(data []byte) (torrentIdentity, error) {
+	var id torrentIdentity
+	if len(data) == 0 || data[0] != 'd' {
+		return id, fmt.Errorf("metainfo is not a dictionary")
+	}
+
+	for pos := 1; pos < len(data) && data[pos] != 'e'; {
+		key, end, err := bdecode(data, pos, 1)
+		if err != nil {
+			return id, err
+		}
+		start := end
+		value, end, err := bdecode(data, start, 1)
+		if err != nil {
+			return id, err
+		}
+		pos = end
+		if key != "info" {
+			continue
+		}
+
+		info, ok := value.(map[string]interface{})
+		if !ok {
+			return id, fmt.Errorf("info is not a dictionary")
+		}
+		sum := sha1.Sum(data[start:end])
+		id.hash = hex.EncodeToString(sum[:])
+		id.name, _ = info["name"].(string)
+		if length, ok := info["length"].(int64); ok {
+			id.size = uint64(length)
+		}
+		files, _ := info["files"].([]interface{})
+		for _, f := range files {
+			if file, ok := f.(map[string]interface{}); ok {
+				if length, ok := file["length"].(int64); ok {
+					id.size += uint64(length)
+				}
+			}
+		}
+		return id, nil
+	}
+	return id, fmt.Errorf("metainfo has no info dictionary")
+}
+
+// bdecode decodes the bencoded value at the position, it returns the value and the position after it.
+// Dictionaries are decoded to maps, lists to slices, integers to int64 and byte strings to strings.
+// depth is the nesting level of the value, too deeply nested values are rejected.
+func bdecode(data []byte, pos int, depth int) (interface{}, int, error) {
+	if pos >= len(data) {
+		return nil, pos, io.ErrUnexpectedEOF
+	}
+	if depth > maxBencodeDepth {
+		return nil, pos, fmt.Errorf("values nested deeper than %d at %d", maxBencodeDepth, pos)
+	}
+
+	switch c := data[pos]; {
+	case c == 'i':
+		end := bytes.IndexByte(data[pos:], 'e')
+		if end < 0 {
+			return nil, pos, io.ErrUnexpectedEOF
+		}
+		n, err := strconv.ParseInt(string(data[pos+1:pos+end]), 10, 64)
+		return n, pos + end + 1, err
+	case c == 'l':
+		var list []interface{}
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			value, end, err := bdecode(data, pos, depth+1)
+			if err != nil {
+				return nil, end, err
+			}
+			list = append(list, value)
+			pos = end
+		}
+		if pos >= len(data) {
+			return nil, pos, io.ErrUnexpectedEOF
+		}
+		return list, pos + 1, nil
+	case c == 'd':
+		dict := make(map[string]interface{})
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			key, end, err := bdecode(data, pos, depth+1)
+			if err != nil {
+				return nil, end, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, pos, fmt.Errorf("dictionary key at %d is not a string", pos)
+			}
+			value, end, err := bdecode(data, end, depth+1)
+			if err != nil {
+				return nil, end, err
+			}
+			dict[name] = value
+			pos = end
+		}
+		if pos >= len(data) {
+			return nil, pos, io.ErrUnexpectedEOF
+		}
+		return dict, pos + 1, nil
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(data[pos:], ':')
+		if colon < 0 {
+			return nil, pos, io.ErrUnexpectedEOF
+		}
+		length, err := strconv.Atoi(string(data[pos : pos+colon]))
+		if err != nil {
+			return nil, pos, err
+		}
+		start := pos + colon + 1
+		if length < 0 || start+length > len(data) {
+			return nil, pos, io.ErrUnexpectedEOF
+		}
+		return string(data[start : start+length]), start + length, nil
+	default:
+		return nil, pos, fmt.Errorf("unexpected %q at %d", c, pos)
+	}
+}
+
+// identifyTorrent returns the identity of a magnet link or a .torrent file URL, the file is downloaded
+// and returned too, so it's not downloaded again to add it
+func identifyTorrent(link string) (torrentIdentity, []byte, error) {
+	if strings.HasPrefix(link, "magnet:") {
+		id, err := parseMagnet(link)
+		return id, nil, err
+	}
+	if !strings.HasPrefix(link, "http://") && !strings.HasPrefix(link, "https://") {
+		return torrentIdentity{}, nil, fmt.Errorf("unsupported URL %s", link)
+	}
+
+	resp, err := metainfoClient.Get(link)
+	if err != nil {
+		return torrentIdentity{}, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return torrentIdentity{}, nil, fmt.Errorf("%s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMetainfoSize))
+	if err != nil {
+		return torrentIdentity{}, nil, err
+	}
+	id, err := parseMetainfo(data)
+	if err != nil {
+		return id, nil, err
+	}
+	return id, data, nil
+}
+
+// findDuplicate returns the torrent with the same hash or the same name and size, or nil
+func findDuplicate(client torrentClient, id torrentIdentity) (*transmission.Torrent, error) {
+	torrents, err := client.GetTorrents()
+	if err != nil {
+		return nil, err
+	}
+	details, err := client.GetTorrentDetails()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range torrents {
+		if d, ok := details[t.ID]; ok && strings.EqualFold(d.HashString, id.hash) {
+			return t, nil
+		}
+	}
+	if id.name == "" || id.size == 0 {
+		return nil, nil
+	}
+	for _, t := range torrents {
+		if t.Name == id.name && t.SizeWhenDone == id.size {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+// findExistingTorrent returns the already added torrent of the URL or nil, and the contents of the .torrent file
+// if it's downloaded. URLs the identity can't be found for are not duplicates, transmission rejects the same hash anyway.
+func findExistingTorrent(client torrentClient, link string) (*transmission.Torrent, []byte) {
+	id, metainfo, err := identifyTorrent(link)
+	if err != nil {
+		// the link of a telegram file has the bot token, so it's not logged
+		log.Printf("[INFO] Duplicate check skipped: %s", err)
+		return nil, nil
+	}
+	t, err := findDuplicate(client, id)
+	if err != nil {
+		log.Printf("[ERROR] Duplicate check: %s", err)
+		return nil, metainfo
+	}
+	return t, metainfo
+}
+
+// findExistingMetainfo returns the already added torrent of the .torrent file contents or nil
+func findExistingMetainfo(client torrentClient, metainfo []byte) *transmission.Torrent {
+	id, err := parseMetainfo(metainfo)
+	if err != nil {
+		// transmission rejects the broken file
+		return nil
+	}
+	t, err := findDuplicate(client, id)
+	if err != nil {
+		log.Printf("[ERROR] Duplicate check: %s", err)
+	}
+	return t
+}
+
+func describeDuplicate(t *transmission.Torrent) string {
+	return fmt.Sprintf("<b>%d</b> <code>%s</code> is already added, <i>%s</i> %.1f%% of %s", t.ID, escape(t.Name),
+		t.TorrentStatus(), t.PercentDone*100, humanize.Bytes(t.SizeWhenDone))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pyed/transmission"
+)
+
+func TestParseMagnet(t *testing.T) {
+	hash, _ := hex.DecodeString("875a2d90068c32b4ce7992eaf56cd03f5be0d193")
+	cases := []struct {
+		link string
+		id   torrentIdentity
+	}{
+		{"magnet:?xt=urn:btih:875A2D90068C32B4CE7992EAF56CD03F5BE0D193&dn=CentOS&xl=100",
+			torrentIdentity{"875a2d90068c32b4ce7992eaf56cd03f5be0d193", "CentOS", 100}},
+		{"magnet:?dn=Other&xt=urn:btih:" + base32.StdEncoding.EncodeToString(hash),
+			torrentIdentity{"875a2d90068c32b4ce7992eaf56cd03f5be0d193", "Other", 0}},
+	}
+	for _, c := range cases {
+		id, err := parseMagnet(c.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != c.id {
+			t.Errorf("parseMagnet(%q) = %+v", c.link, id)
+		}
+	}
+	if _, err := parseMagnet("magnet:?dn=nohash"); err == nil {
+		t.Error("Magnet without a hash is parsed")
+	}
+}
+
+func TestParseMetainfo(t *testing.T) {
+	single := "d4:name4:file6:lengthi1000e12:piece lengthi16384ee"
+	multi := "d5:filesld6:lengthi10e4:pathl1:aeed6:lengthi20e4:pathl1:beee4:name3:dir12:piece lengthi16384ee"
+	cases := []struct {
+		info string
+		name string
+		size uint64
+	}{
+		{single, "file", 1000},
+		{multi, "dir", 30},
+	}
+	for _, c := range cases {
+		data := "d8:announce19:http://example.org/7:comment2:hi4:info" + c.info + "e"
+		id, err := parseMetainfo([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha1.Sum([]byte(c.info))
+		expected := torrentIdentity{hex.EncodeToString(sum[:]), c.name, c.size}
+		if id != expected {
+			t.Errorf("parseMetainfo(%q) = %+v, expected %+v", data, id, expected)
+		}
+	}
+
+	deep := "d4:info" + strings.Repeat("l", maxBencodeDepth) + strings.Repeat("e", maxBencodeDepth) + "e"
+	for _, data := range []string{"", "le", "d4:infoi1ee", "d4:info", "d3:foo3:bare", deep} {
+		if _, err := parseMetainfo([]byte(data)); err == nil {
+			t.Errorf("parseMetainfo(%q) has no error", data)
+		}
+	}
+}
+
+func TestFindExistingTorrent(t *testing.T) {
+	info := "d4:name4:file6:lengthi1000ee"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d4:info" + info + "e"))
+	}))
+	defer server.Close()
+	sum := sha1.Sum([]byte(info))
+
+	client := &fakeTorrentClient{
+		torrents: transmission.Torrents{
+			{ID: 1, Name: "other", SizeWhenDone: 1000},
+			{ID: 2, Name: "by hash", SizeWhenDone: 5},
+			{ID: 3, Name: "show", SizeWhenDone: 500},
+		},
+		hashes: map[int]string{1: "aaa", 2: hex.EncodeToString(sum[:]), 3: "ccc"},
+	}
+
+	cases := []struct {
+		link      string
+		duplicate bool
+	}{
+		{server.URL, true},
+		{"magnet:?xt=urn:btih:" + hex.EncodeToString(sum[:]), true},
+		{"magnet:?xt=urn:btih:875a2d90068c32b4ce7992eaf56cd03f5be0d193&dn=show&xl=500", true},
+		{"magnet:?xt=urn:btih:875a2d90068c32b4ce7992eaf56cd03f5be0d193&dn=show&xl=501", false},
+		{"magnet:?xt=urn:btih:875a2d90068c32b4ce7992eaf56cd03f5be0d193&dn=show", false},
+		{server.URL + "/file.torrent", true},
+		{"ftp://example.org/file.torrent", false},
+	}
+	for _, c := range cases {
+		if existing, _ := findExistingTorrent(client, c.link); (existing != nil) != c.duplicate {
+			t.Errorf("%s is a duplicate: %v", c.link, existing != nil)
+		}
+	}
+
+	// the downloaded file is added without downloading it again
+	client.hashes[2] = "bbb"
+	existing, metainfo := findExistingTorrent(client, server.URL)
+	if existing != nil || string(metainfo) != "d4:info"+info+"e" {
+		t.Errorf("Wrong metainfo %q of a new torrent", metainfo)
+	}
+}
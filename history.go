@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const historySampleInterval = 10 * time.Second
+
+// historyTier is a series of speed averages over step long periods kept for retention
+type historyTier struct {
+	name      string
+	step      time.Duration
+	retention time.Duration
+
+	start    time.Time
+	down, up uint64
+	count    uint64
+}
+
+// graphPeriods maps graph arguments to the period and the tier it's drawn from
+var graphPeriods = map[string]struct {
+	period     time.Duration
+	tier       string
+	timeFormat string
+}{
+	"1h":  {time.Hour, "1m", "15:04"},
+	"24h": {24 * time.Hour, "1m", "15:04"},
+	"7d":  {7 * 24 * time.Hour, "15m", "01-02"},
+}
+
+func newHistoryTiers() []*historyTier {
+	return []*historyTier{
+		{name: "1m", step: time.Minute, retention: 48 * time.Hour},
+		{name: "15m", step: 15 * time.Minute, retention: 30 * 24 * time.Hour},
+	}
+}
+
+func tierStep(name string) time.Duration {
+	for _, tier := range newHistoryTiers() {
+		if tier.name == name {
+			return tier.step
+		}
+	}
+	return time.Minute
+}
+
+// add accumulates the sample, the average of the previous period is returned when a new period begins
+func (t *historyTier) add(now time.Time, down uint64, up uint64) (settings.SpeedSample, bool) {
+	start := now.Truncate(t.step)
+	var sample settings.SpeedSample
+	var done bool
+	if !start.Equal(t.start) {
+		if t.count > 0 {
+			sample = settings.SpeedSample{Time: t.start, Down: t.down / t.count, Up: t.up / t.count}
+			done = true
+		}
+		t.start, t.down, t.up, t.count = start, 0, 0, 0
+	}
+	t.down += down
+	t.up += up
+	t.count++
+	return sample, done
+}
+
+// sampleHistory periodically samples the speeds and stores their averages
+func sampleHistory(client torrentClient, s settings.Settings) {
+	tiers := newHistoryTiers()
+	for {
+		stats, err := client.GetStats()
+		if err != nil {
+			log.Println("GetStats failed:", err.Error())
+		} else {
+			now := time.Now()
+			for _, tier := range tiers {
+				if sample, ok := tier.add(now, stats.DownloadSpeed, stats.UploadSpeed); ok {
+					if err := s.AddSpeedSample(tier.name, sample, tier.retention); err != nil {
+						log.Println("AddSpeedSample failed:", err.Error())
+					}
+				}
+			}
+		}
+		time.Sleep(historySampleInterval)
+	}
+}
+
+// graph sends a chart of download and upload speeds for the last 1h, 24h or 7d
+func graph(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	arg := "24h"
+	if len(ud.Tokens()) > 0 {
+		arg = strings.ToLower(ud.Tokens()[0])
+	}
+	period, ok := graphPeriods[arg]
+	if !ok {
+		send(bot, "<b>graph</b>: takes one of <code>1h</code>, <code>24h</code> or <code>7d</code>", ud.Chat.ID, ud.Role())
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-period.period)
+	samples, err := s.GetSpeedSamples(period.tier, from)
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>graph</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	if len(samples) == 0 {
+		send(bot, "<b>graph</b>: no history yet", ud.Chat.ID, ud.Role())
+		return
+	}
+
+	img, err := renderSpeedChart(samples, from, to, 3*tierStep(period.tier), period.timeFormat)
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>graph</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	var maxDown, maxUp, sumDown, sumUp uint64
+	for _, sample := range samples {
+		if sample.Down > maxDown {
+			maxDown = sample.Down
+		}
+		if sample.Up > maxUp {
+			maxUp = sample.Up
+		}
+		sumDown += sample.Down
+		sumUp += sample.Up
+	}
+	n := uint64(len(samples))
+
+	photo := tgbotapi.NewPhotoUpload(ud.Chat.ID, tgbotapi.FileBytes{Name: "graph.png", Bytes: img})
+	photo.Caption = fmt.Sprintf("Last %s\nPeak: ↓ %s/s  ↑ %s/s\nAverage: ↓ %s/s  ↑ %s/s", arg,
+		humanize.Bytes(maxDown), humanize.Bytes(maxUp), humanize.Bytes(sumDown/n), humanize.Bytes(sumUp/n))
+	if _, err := bot.Send(photo); err != nil {
+		send(bot, fmt.Sprintf("<b>graph</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+	}
+}
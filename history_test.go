@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+func TestHistoryTierAverage(t *testing.T) {
+	tier := &historyTier{name: "1m", step: time.Minute}
+	start := time.Unix(600, 0)
+
+	for i := 0; i < 6; i++ {
+		if _, ok := tier.add(start.Add(time.Duration(i)*10*time.Second), uint64(i*100), 10); ok {
+			t.Fatal("Sample is returned before the period ends")
+		}
+	}
+	sample, ok := tier.add(start.Add(time.Minute), 0, 0)
+	if !ok {
+		t.Fatal("Sample is not returned")
+	}
+	if !sample.Time.Equal(start) || sample.Down != 250 || sample.Up != 10 {
+		t.Fatalf("Wrong sample: %+v", sample)
+	}
+}
+
+func TestRenderSpeedChart(t *testing.T) {
+	to := time.Now()
+	from := to.Add(-time.Hour)
+	var samples []settings.SpeedSample
+	for i := 0; i < 60; i++ {
+		samples = append(samples, settings.SpeedSample{Time: from.Add(time.Duration(i) * time.Minute), Down: uint64(i * 100000), Up: uint64(i * 20000)})
+	}
+
+	data, err := renderSpeedChart(samples, from, to, 3*time.Minute, "15:04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != chartWidth || img.Bounds().Dy() != chartHeight {
+		t.Fatal("Wrong image size")
+	}
+}
+
+func TestNiceCeil(t *testing.T) {
+	for v, expected := range map[float64]float64{0: 1024, 3: 5, 120: 200, 999: 1000, 1000: 1000, 4e6: 5e6} {
+		if niceCeil(v) != expected {
+			t.Fatalf("niceCeil(%v) = %v", v, niceCeil(v))
+		}
+	}
+}
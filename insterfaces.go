@@ -28,10 +28,16 @@ type telegramClient interface {
 
 type torrentClient interface {
 	GetTorrents() (transmission.Torrents, error)
+	GetRecentlyActive() (transmission.Torrents, []int, error)
 	GetTorrent(int) (*transmission.Torrent, error)
 	GetStats() (*transmission.Stats, error)
 	AddByURL(url string) (transmission.TorrentAdded, error)
+	AddTorrent(addArguments) (transmission.TorrentAdded, error)
+	SetLocation(id int, dir string) error
 	SetSort(transmission.Sorting)
+	GetSession() (*session, error)
+	FreeSpace(string) (uint64, error)
+	GetTorrentDetails() (map[int]*torrentDetails, error)
 
 	Version() string
 	DeleteTorrent(int, bool) (string, error)
@@ -6,33 +6,42 @@ import (
 	"gopkg.in/telegram-bot-api.v4"
 )
 
-func commandsKeyboard() *tgbotapi.ReplyKeyboardMarkup {
+func commandsKeyboard(r role) *tgbotapi.ReplyKeyboardMarkup {
 	// progress stop all start all stats
 	row1 := []tgbotapi.KeyboardButton{tgbotapi.NewKeyboardButton("list"),
 		tgbotapi.NewKeyboardButton("speed"),
-		tgbotapi.NewKeyboardButton("start all"),
-		tgbotapi.NewKeyboardButton("notifications on"),
 	}
-
 	row2 := []tgbotapi.KeyboardButton{tgbotapi.NewKeyboardButton("progress"),
 		tgbotapi.NewKeyboardButton("stats"),
-		tgbotapi.NewKeyboardButton("stop all"),
-		tgbotapi.NewKeyboardButton("notifications off"),
 	}
+	if r >= roleOperator {
+		row1 = append(row1, tgbotapi.NewKeyboardButton("start all"))
+		row2 = append(row2, tgbotapi.NewKeyboardButton("stop all"))
+	}
+	row1 = append(row1, tgbotapi.NewKeyboardButton("notifications on"))
+	row2 = append(row2, tgbotapi.NewKeyboardButton("notifications off"))
+
 	commandsKeyboard := tgbotapi.NewReplyKeyboard(row1, row2)
 
 	return &commandsKeyboard
 }
 
-func torrentKeyboard(torrentID int) *tgbotapi.InlineKeyboardMarkup {
-	row1 := []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("stop", fmt.Sprintf("stop %d", torrentID)),
-		tgbotapi.NewInlineKeyboardButtonData("start", fmt.Sprintf("start %d", torrentID)),
-		tgbotapi.NewInlineKeyboardButtonData("del", fmt.Sprintf("del %d", torrentID)),
+func torrentKeyboard(torrentID int, r role) *tgbotapi.InlineKeyboardMarkup {
+	var row1, row2 []tgbotapi.InlineKeyboardButton
+	if r >= roleOperator {
+		row1 = append(row1,
+			tgbotapi.NewInlineKeyboardButtonData("stop", fmt.Sprintf("stop %d", torrentID)),
+			tgbotapi.NewInlineKeyboardButtonData("start", fmt.Sprintf("start %d", torrentID)),
+		)
+		row2 = append(row2, tgbotapi.NewInlineKeyboardButtonData("check", fmt.Sprintf("check %d", torrentID)))
+	}
+	if r >= roleAdmin {
+		row1 = append(row1, tgbotapi.NewInlineKeyboardButtonData("del", fmt.Sprintf("del %d", torrentID)))
+		row2 = append(row2, tgbotapi.NewInlineKeyboardButtonData("deldata", fmt.Sprintf("deldata %d", torrentID)))
 	}
-	row2 := []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("check", fmt.Sprintf("check %d", torrentID)),
-		tgbotapi.NewInlineKeyboardButtonData("deldata", fmt.Sprintf("deldata %d", torrentID)),
+	if len(row1) == 0 {
+		// viewers can't do anything with a torrent
+		return nil
 	}
 	commandsKeyboard := tgbotapi.NewInlineKeyboardMarkup(row1, row2)
 	return &commandsKeyboard
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// liveViewLifetime is how long live views keep updating
+var liveViewLifetime = 2 * time.Minute
+
+// viewRenderer returns the text and keyboard of a live view for the polled torrents. An error ends the view,
+// its message is shown to the user, so it must be formatted as the message text.
+type viewRenderer func(torrents transmission.Torrents) (string, *tgbotapi.InlineKeyboardMarkup, error)
+
+type viewKey struct {
+	chatID int64
+	kind   string
+}
+
+type liveView struct {
+	key    viewKey
+	cancel context.CancelFunc
+	msgID  int
+}
+
+// liveViewRegistry keeps one active live view per chat and kind
+type liveViewRegistry struct {
+	mu    sync.Mutex
+	views map[viewKey]*liveView
+}
+
+var liveViews = &liveViewRegistry{views: make(map[viewKey]*liveView)}
+
+// start registers a new view, the view of the same kind is stopped and its message is taken over
+func (r *liveViewRegistry) start(chatID int64, kind string) (context.Context, *liveView) {
+	ctx, cancel := context.WithTimeout(context.Background(), liveViewLifetime)
+	view := &liveView{key: viewKey{chatID, kind}, cancel: cancel}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.views[view.key]; ok {
+		old.cancel()
+		view.msgID = old.msgID
+	}
+	r.views[view.key] = view
+	return ctx, view
+}
+
+// owns checks that the view is still the active one
+func (r *liveViewRegistry) owns(view *liveView) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.views[view.key] == view
+}
+
+// setMessage stores the message of the view, returns false if the view was taken over before the message
+// was sent, the new view has no message to take then
+func (r *liveViewRegistry) setMessage(view *liveView, msgID int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	view.msgID = msgID
+	return r.views[view.key] == view
+}
+
+// finish unregisters the view, returns false if the view was taken over by another one
+func (r *liveViewRegistry) finish(view *liveView) bool {
+	view.cancel()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.views[view.key] != view {
+		return false
+	}
+	delete(r.views, view.key)
+	return true
+}
+
+// stop stops the view of the kind in the chat, returns false if there is no such view
+func (r *liveViewRegistry) stop(chatID int64, kind string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	view, ok := r.views[viewKey{chatID, kind}]
+	if ok {
+		view.cancel()
+	}
+	return ok
+}
+
+// runLiveView sends the view and keeps updating it on every poll of the torrents until it's stopped,
+// taken over by a new view of the same kind or expired. final returns the text left in the message
+// when the view ends, the last text stays if final is nil.
+func runLiveView(bot telegramClient, chatID int64, kind string, render viewRenderer, final func(string) string) {
+	ctx, view := liveViews.start(chatID, kind)
+	sub := poller.subscribe()
+	defer poller.unsubscribe(sub)
+
+	var text string
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	for {
+		select {
+		case <-ctx.Done():
+			if liveViews.finish(view) && view.msgID != 0 {
+				if final != nil {
+					text = final(text)
+				}
+				edit(bot, text, chatID, view.msgID, keyboard)
+			}
+			return
+		case update := <-sub.C:
+			if !liveViews.owns(view) {
+				liveViews.finish(view)
+				return
+			}
+			if update.err != nil {
+				// the view keeps updating, the next poll may succeed
+				text, keyboard = fmt.Sprintf("<b>%s</b>: <code>%s</code>", escape(kind), escape(update.err.Error())), nil
+				if view.msgID == 0 {
+					if !sendLiveView(bot, view, text, chatID, withStopButton(nil, kind)) {
+						return
+					}
+				} else {
+					edit(bot, text, chatID, view.msgID, withStopButton(nil, kind))
+				}
+				continue
+			}
+			t, k, err := render(update.torrents)
+			if err != nil {
+				if view.msgID == 0 {
+					send(bot, err.Error(), chatID, roleNone)
+				} else {
+					edit(bot, err.Error(), chatID, view.msgID, nil)
+				}
+				liveViews.finish(view)
+				return
+			}
+
+			text, keyboard = t, k
+			if view.msgID == 0 {
+				if !sendLiveView(bot, view, text, chatID, withStopButton(keyboard, kind)) {
+					return
+				}
+			} else {
+				edit(bot, text, chatID, view.msgID, withStopButton(keyboard, kind))
+			}
+		}
+	}
+}
+
+// sendLiveView sends the first message of the view, returns false if the view was taken over meanwhile,
+// the message is deleted then as the new view sends its own
+func sendLiveView(bot telegramClient, view *liveView, text string, chatID int64, keyboard *tgbotapi.InlineKeyboardMarkup) bool {
+	msgID := sendWithKeyboard(bot, text, chatID, keyboard)
+	if liveViews.setMessage(view, msgID) {
+		return true
+	}
+	if msgID != 0 {
+		bot.Send(tgbotapi.NewDeleteMessage(chatID, msgID))
+	}
+	liveViews.finish(view)
+	return false
+}
+
+// withStopButton adds the "Stop updating" button to the keyboard of a live view
+func withStopButton(keyboard *tgbotapi.InlineKeyboardMarkup, kind string) *tgbotapi.InlineKeyboardMarkup {
+	row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Stop updating", "stopview "+kind))
+	result := tgbotapi.NewInlineKeyboardMarkup(row)
+	if keyboard != nil {
+		result.InlineKeyboard = append(append([][]tgbotapi.InlineKeyboardButton{}, keyboard.InlineKeyboard...), row)
+	}
+	return &result
+}
+
+// stopView stops a live view, it's sent by the "Stop updating" button
+func stopView(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	kind := strings.Join(ud.Tokens(), " ")
+	if !liveViews.stop(ud.Chat.ID, kind) {
+		send(bot, "<b>stopview</b>: the view has already stopped", ud.Chat.ID, ud.Role())
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestLiveViewTakeOver(t *testing.T) {
+	ctx, first := liveViews.start(1, "speed")
+	liveViews.setMessage(first, 42)
+
+	_, second := liveViews.start(1, "speed")
+	if ctx.Err() == nil {
+		t.Fatal("The old view is not cancelled")
+	}
+	if second.msgID != 42 {
+		t.Fatal("The message is not taken over")
+	}
+	if liveViews.finish(first) {
+		t.Fatal("The old view still owns the message")
+	}
+
+	if !liveViews.stop(1, "speed") {
+		t.Fatal("The view is not stopped")
+	}
+	if !liveViews.finish(second) {
+		t.Fatal("The view doesn't own the message")
+	}
+	if liveViews.stop(1, "speed") {
+		t.Fatal("Finished view is stopped")
+	}
+}
+
+func TestLiveViewTakeOverBeforeMessage(t *testing.T) {
+	_, first := liveViews.start(2, "speed")
+	_, second := liveViews.start(2, "speed")
+	if second.msgID != 0 {
+		t.Fatal("The new view takes a message which is not sent yet")
+	}
+	// the old view sends its message after the new one has started
+	if liveViews.setMessage(first, 42) {
+		t.Fatal("The old view keeps its message")
+	}
+	liveViews.finish(first)
+	if !liveViews.setMessage(second, 43) || !liveViews.finish(second) {
+		t.Fatal("The new view doesn't own its message")
+	}
+}
@@ -8,10 +8,7 @@ import (
 	"os/user"
 	"path"
 	"runtime/debug"
-	"sort"
-	"strings"
 
-	"github.com/pyed/transmission"
 	"github.com/zhulik/transmission-telegram/settings"
 	"gopkg.in/telegram-bot-api.v4"
 )
@@ -22,99 +19,160 @@ const (
 
 	// HELP message for help command
 	HELP = `
-	*ls* or *list* [dl, sd, pa, ch, er]
+	<b>ls</b> or <b>list</b> [dl, sd, pa, ch, er]
 	Lists the torrents. Optional argument:
-		*dl* - Lists torrents with the status of Downloading or in the queue to download.
-		*sd* - Lists torrents with the status of Seeding or in the queue to seed.
-		*pa* - Lists Paused torrents.
-		*ch* - Lists torrents with the status of Verifying or in the queue to verify.
-		*er* - Lists torrents with with errors along with the error message.
-
-	*search* or *se*
+		<b>dl</b> - Lists torrents with the status of Downloading or in the queue to download.
+		<b>sd</b> - Lists torrents with the status of Seeding or in the queue to seed.
+		<b>pa</b> - Lists Paused torrents.
+		<b>ch</b> - Lists torrents with the status of Verifying or in the queue to verify.
+		<b>er</b> - Lists torrents with with errors along with the error message.
+		<b>mine</b> - Lists torrents added by you.
+	The users who added the torrents are shown in the list and in <b>info</b>.
+
+	<b>search</b> or <b>se</b>
 	Takes a query and lists torrents with matching names.
 
-	*sort* or *so*
-	Manipulate the sorting of the aforementioned commands, Call it without arguments for more.
+	<b>sort</b> or <b>so</b>
+	Manipulate the sorting of the aforementioned commands for everyone, operators only. Call it without arguments for more.
 
-	*add* or *ad*
-	Takes one or many URLs or magnets to add them, You can send a .torrent file via Telegram to add it.
+	<b>add</b> or <b>ad</b>
+	Takes one or many URLs or magnets to add them, You can send a .torrent file via Telegram to add it. Torrents already added with the same hash, or the same name and size, are reported instead.
 
-	*info* or *in*
+	<b>info</b> or <b>in</b>
 	Takes one or more torrent's IDs to list more info about them.
 
-	*stop* or *sp*
-	Takes one or more torrent's IDs to stop them, or _all_ to stop all torrents.
+	<b>stop</b> or <b>sp</b>
+	Takes one or more torrent's IDs to stop them, or <i>all</i> to stop all torrents.
+
+	<b>start</b> or <b>st</b>
+	Takes one or more torrent's IDs to start them, or <i>all</i> to start all torrents.
+
+	<b>check</b> or <b>ck</b>
+	Takes one or more torrent's IDs to verify them, or <i>all</i> to verify all torrents.
+
+	<b>notifications</b> or <b>ns</b> [event] on|off
+	Shows or toggles notifications sent to you: added, finished, error, stalled, goal, verified, removed or all, finished without an event. <i>notifications stalled on 60</i> also sets the minutes without progress.
+	<i>notifications quiet 23:00-08:00</i> holds notifications during the hours and sends them together afterwards, <i>notifications timezone Europe/Berlin</i> sets the time zone of quiet hours and digests.
+	Finished torrents are notified to the users who added them, <i>notifications scope all</i> notifies you about everyone's torrents.
+	<b>notify rule</b> add|list|rm limits notifications to torrents matching any rule: <i>name:text</i>, <i>tracker:example.org</i>, <i>dir:/downloads/tv</i>, values can be /regexps/.
 
-	*start* or *st*
-	Takes one or more torrent's IDs to start them, or _all_ to start all torrents.
+	<b>digest</b> daily|weekly|off
+	Schedules a summary of added, finished and errored torrents and transferred bytes, e.g. <i>digest daily 09:00</i> or <i>digest weekly mon 09:00</i>.
 
-	*check* or *ck*
-	Takes one or more torrent's IDs to verify them, or _all_ to verify all torrents.
+	<b>quota</b>
+	Shows how much of your quota your torrents take. Admins can see quotas of others with <i>quota @user</i> and change them with <i>quota set @user active|total|single value</i>, e.g. <i>quota set @user total 100GB</i>, 0 removes the limit.
 
-	*del*
+	<b>rss</b> add|list|rm|rule|check
+	Watches RSS and Atom feeds, <i>rss add url</i> adds a feed and <i>rss rule 1 add /S\d\dE\d\d/ min:200MB max:2GB episodes dir:/downloads/tv</i> downloads its new items matching the rule, <i>episodes</i> skips episodes already downloaded.
+
+	<b>schedule</b> add|list|rm
+	Runs commands at cron times and sends you their output, e.g. <i>schedule add "0 8 * * 1-5" stop all</i> stops all torrents on weekday mornings. The times are in your time zone.
+
+	<b>del</b>
 	Takes one or more torrent's IDs to delete them.
 
-	*deldata*
+	<b>deldata</b>
 	Takes one or more torrent's IDs to delete them and their data.
 
-	*stats* or *sa*
+	<b>action</b> add|list|rm
+	Applies actions to finished torrents, <i>action add move /downloads/tv name:/S\d\dE\d\d/</i> moves the data of matching torrents, <i>action add exec scan tracker:example.org</i> runs the scan executable from the hooks directory and reports its exit status, admins only.
+
+	<b>cleanup</b> add|list|dry|enable|disable|rm|keep|unkeep|run
+	Removes finished torrents automatically, e.g. <i>cleanup add ratio:2 seeding:30d idle:14d tracker:example.org data</i> removes torrents of the tracker with their data once any condition is met. New policies are disabled and report what they would remove, <i>cleanup enable 1</i> enables one. <i>cleanup keep 5</i> exempts a torrent, admins only.
+
+	<b>stats</b> or <b>sa</b>
 	Shows Transmission's stats.
 
-	*speed* or *ss*
+	<b>speed</b> or <b>ss</b>
 	Shows the upload and download speeds.
 
-	*count* or *co*
+	Live messages of <b>info</b>, <b>speed</b> and <b>progress</b> keep updating for a while, press <i>Stop updating</i> to stop them earlier.
+
+	<b>dashboard</b> or <b>db</b>
+	Posts and pins a message with speeds, torrents counts, active downloads and free space which keeps updating, <b>dashboard off</b> stops it.
+
+	<b>graph</b> or <b>gr</b> [1h, 24h, 7d]
+	Sends a chart of download and upload speeds for the period, 24h by default.
+
+	<b>count</b> or <b>co</b>
 	Shows the torrents counts per status.
 
-	*help*
+	<b>users</b>
+	Lists the users and their roles, admins only.
+
+	<b>user</b> add|remove
+	Takes a user ID or @username and a role (viewer, operator, admin) to add a user, or a user ID or @username to remove one, admins only.
+
+	<b>invite</b>
+	Generates a one-time code for a new user with an optional role, the user joins by sending /start with the code, admins only.
+
+	<b>audit</b> [n] [user]
+	Shows the last executed commands, optionally only of the user, admins only. <b>audit export</b> sends the whole log as a JSONL file.
+
+	<b>help</b>
 	Shows this help message.
 
-	*version*
+	<b>version</b>
 	Shows version numbers.
 
+	- Viewers can only list and watch torrents, operators can also add, start, stop and check them, admins can also delete them.
 	- Prefix commands with '/' if you want to talk to your bot in a group.
-	- report any issues [here](https://github.com/pyed/transmission-telegram)
+	- report any issues <a href="https://github.com/pyed/transmission-telegram">here</a>
 	`
 )
 
 func main() {
 	var botToken string
-	var masterUsernames string
+	var masters string
+	var operators string
+	var viewers string
+	var groups string
 	var transmissionURL string
 	var transmissionUsername string
 	var transmissionPassword string
 	var logFile string
+	var watchDirectory string
+	var watchUser int
 	var verbose bool
+	var webhook webhookConfig
 
 	flag.StringVar(&botToken, "token", "", "Telegram bot token")
-	flag.StringVar(&masterUsernames, "masters", "", "Your telegram handlers, separated with comma, so the bot will only respond to them")
+	flag.StringVar(&masters, "masters", "", "Your telegram user IDs or usernames, separated with comma, they become the initial admins")
+	flag.StringVar(&operators, "operators", "", "Telegram user IDs or usernames of the initial operators, allowed to add, start, stop and verify torrents")
+	flag.StringVar(&viewers, "viewers", "", "Telegram user IDs or usernames of the initial viewers, allowed only to view torrents")
+	flag.StringVar(&groups, "groups", "", "IDs of group chats the bot is allowed to work in, separated with comma")
 	flag.StringVar(&transmissionURL, "url", "http://localhost:9091/transmission/rpc", "Transmission RPC URL")
 	flag.StringVar(&transmissionUsername, "username", "", "Transmission username")
 	flag.StringVar(&transmissionPassword, "password", "", "Transmission password")
 	flag.StringVar(&logFile, "logfile", "", "Send logs to a file")
 	flag.BoolVar(&verbose, "v", false, "Verbose output")
+	flag.DurationVar(&liveViewLifetime, "live", liveViewLifetime, "How long info, speed and progress messages keep updating")
+	flag.DurationVar(&rssInterval, "rss-interval", rssInterval, "How often RSS feeds are checked")
+	flag.StringVar(&watchDirectory, "watch-dir", "", "Directory to add dropped .torrent and .magnet files from")
+	flag.IntVar(&watchUser, "watch-user", 0, "Telegram user ID the watched files are added for, the first admin if it's 0")
+	flag.StringVar(&hooksDir, "hooks-dir", "", "Directory of the executables the exec actions can run on finished torrents")
+	flag.StringVar(&webhook.URL, "webhook", "", "Public webhook URL, updates are received with long polling if it's empty")
+	flag.StringVar(&webhook.Listen, "listen", ":8443", "Address to listen for webhook requests on")
+	flag.StringVar(&webhook.CertFile, "cert", "", "Webhook TLS certificate file, a self-signed one is generated if it's empty")
+	flag.StringVar(&webhook.KeyFile, "key", "", "Webhook TLS key file")
+	flag.StringVar(&webhook.Secret, "webhook-secret", "", "Webhook secret token, a random one is generated if it's empty")
+	flag.BoolVar(&webhook.Proxy, "webhook-proxy", false, "Receive webhook requests with plain HTTP from a proxy terminating TLS, without -cert and -key")
 
 	// set the usage message
 	flag.Usage = func() {
-		fmt.Fprint(os.Stderr, "Usage: transmission-bot -token=<TOKEN> -masters=<user,user2> -url=[http://] -username=[user] -password=[pass]\n\n")
+		fmt.Fprint(os.Stderr, "Usage: transmission-bot -token=<TOKEN> -masters=<id,@user2> -url=[http://] -username=[user] -password=[pass]\n\n")
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
 
 	// make sure that we have the two madatory arguments: telegram token & master's handler.
-	if botToken == "" || masterUsernames == "" {
+	if botToken == "" || masters == "" {
 		fmt.Fprintf(os.Stderr, "Error: Mandatory argument missing! (-token or -masters)\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// make sure that the handler doesn't contain @
-	masterUsernames = strings.ToLower(strings.Replace(masterUsernames, "@", "", -1))
-
-	masters := strings.Split(masterUsernames, ",")
-	sort.Strings(masters)
-
 	// if we got a log file, log to it
 	if logFile != "" {
 		logf, err := os.OpenFile(logFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -124,17 +182,15 @@ func main() {
 		log.SetOutput(logf)
 	}
 	// log the flags
-	log.Printf("[INFO] Token=%s\nMasters=%s\nURL=%s\nUSER=%s\nPASS=%s",
-		botToken, masterUsernames, transmissionURL, transmissionUsername, transmissionPassword)
+	log.Printf("[INFO] Token=%s\nMasters=%s\nGroups=%s\nURL=%s\nUSER=%s\nPASS=%s",
+		botToken, masters, groups, transmissionURL, transmissionUsername, transmissionPassword)
 
-	transmission, err := transmission.New(transmissionURL, transmissionUsername, transmissionPassword)
+	client, err := newTransmissionClient(transmissionURL, transmissionUsername, transmissionPassword)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[ERROR] Transmission: Make sure you have the right URL, Username and Password")
 		os.Exit(1)
 	}
 
-	client := transmissionClient{client: transmission}
-
 	bot, err := tgbotapi.NewBotAPI(botToken)
 	bot.Debug = verbose
 	if err != nil {
@@ -143,16 +199,25 @@ func main() {
 	}
 	log.Printf("[INFO] Authorized: %s", bot.Self.UserName)
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-
-	updates, err := bot.GetUpdatesChan(u)
+	var updates tgbotapi.UpdatesChannel
+	// serverErrors stays nil with long polling
+	var serverErrors <-chan error
+	if webhook.URL != "" {
+		updates, serverErrors, err = listenForWebhook(bot, webhook)
+	} else {
+		// getUpdates doesn't work while a webhook is set
+		bot.RemoveWebhook()
+
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = 60
+		updates, err = bot.GetUpdatesChan(u)
+	}
 	if err != nil {
 		log.Printf("[ERROR] Telegram: %s", err)
 		os.Exit(1)
 	}
 
-	b := &telegramClientWrapper{bot: bot}
+	b := newDispatcher(&telegramClientWrapper{bot: bot})
 
 	usr, err := user.Current()
 	if err != nil {
@@ -171,18 +236,71 @@ func main() {
 		log.Println(err)
 		os.Exit(1)
 	}
+	// notification settings used to be stored by usernames
+	if err := s.MigrateUserNotifications(); err != nil {
+		log.Println("MigrateUserNotifications failed:", err.Error())
+	}
+
+	auth, err := newAuthorizer(groups, s)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	// the flags only seed the initial users, later they are managed with the user command
+	roles, err := s.GetUserRoles()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	if len(roles) == 0 {
+		// the higher role wins for the users in several lists
+		for _, seed := range []struct {
+			users string
+			r     role
+		}{{viewers, roleViewer}, {operators, roleOperator}, {masters, roleAdmin}} {
+			if err := auth.seed(seed.users, seed.r); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+		}
+	}
 
-	go notifyFinished(b, client, masters, s)
+	poller = newTorrentPoller(client)
+	// the first poll finds what has finished since the bot was stopped
+	poller.states = newTorrentStates(client, s)
+	go notifyEvents(b, client, poller.subscribe(), auth, s)
+	go runCompletionActions(b, client, poller.subscribe(), s)
+	go poller.run()
+	go sampleHistory(client, s)
+	go sendDigests(b, client, s)
+	go watchFeeds(b, client, s)
+	go runSchedules(b, client, auth, s)
+	torrentCleaner = &cleaner{auth: auth}
+	go torrentCleaner.runEvery(b, client, s)
+	if watchDirectory != "" {
+		go watchDir(b, client, auth, s, watchDirectory, watchUser)
+	}
+	resumeDashboards(b, client, s)
+
+	for {
+		var update tgbotapi.Update
+		select {
+		case update = <-updates:
+		case err := <-serverErrors:
+			log.Printf("[ERROR] Webhook: %s", err)
+			os.Exit(1)
+		}
 
-	for update := range updates {
 		var wrapper messageWrapper
 		if update.Message == nil {
 			if update.EditedMessage != nil {
-				wrapper = wrapMessage(update.EditedMessage)
+				wrapper = wrapMessage(update.EditedMessage, bot.Self.UserName)
 			} else {
 				if update.CallbackQuery != nil {
-					msg := tgbotapi.Message{MessageID: update.CallbackQuery.Message.MessageID, Chat: update.CallbackQuery.Message.Chat, Text: update.CallbackQuery.Data}
-					wrapper = wrapMessage(&msg)
+					// the callback message is sent by the bot, so the sender is taken from the query
+					msg := tgbotapi.Message{MessageID: update.CallbackQuery.Message.MessageID, From: update.CallbackQuery.From,
+						Chat: update.CallbackQuery.Message.Chat, Text: update.CallbackQuery.Data}
+					wrapper = wrapMessage(&msg, bot.Self.UserName)
 					answer := tgbotapi.NewCallback(update.CallbackQuery.ID, "")
 					bot.AnswerCallbackQuery(answer)
 				} else {
@@ -190,78 +308,142 @@ func main() {
 				}
 			}
 		} else {
-			wrapper = wrapMessage(update.Message)
+			wrapper = wrapMessage(update.Message, bot.Self.UserName)
 		}
 
-		// ignore anyone other than 'masters'
-		if sort.SearchStrings(masters, strings.ToLower(wrapper.Chat.UserName)) == len(masters) {
-			log.Printf("[INFO] Ignored a message from: %s", wrapper.Message.From.String())
+		// ignore anyone without a role
+		r := auth.role(wrapper.Message)
+		if r == roleNone {
+			if redeemInvite(b, wrapper, s) {
+				continue
+			}
+			log.Printf("[INFO] Ignored a message from: %s in chat %d", wrapper.Message.From.String(), wrapper.Chat.ID)
 			continue
 		}
 
-		s.SetUserID(wrapper.Chat.UserName, wrapper.Chat.ID)
+		// commands mentioning other bots in a group
+		if wrapper.ForAnotherBot() {
+			continue
+		}
+
+		wrapper.role = r
 
 		go func() {
 			defer func() {
 				if recover() != nil {
-					send(b, "PANIC: something goes wrong...", wrapper.Message.Chat.ID, true)
+					send(b, "PANIC: something goes wrong...", wrapper.Message.Chat.ID, wrapper.Role())
 					log.Println(string(debug.Stack()))
 				}
 			}()
-			findHandler(wrapper.Command())(b, client, wrapper, s)
+			runAudited(findHandler(wrapper.Command(), wrapper.Role()), b, client, wrapper, s)
 		}()
 
 	}
 }
 
-func findHandler(command string) commandHandler {
+// findHandler returns the handler of the command if the role allows to run it
+func findHandler(command string, r role) commandHandler {
+	handler, required := lookupCommand(command)
+	if handler == nil {
+		return unknownCommand
+	}
+	if r < required {
+		return forbidden
+	}
+	return handler
+}
+
+// lookupCommand returns the handler of the command and the minimal role required to run it,
+// the handler is nil for unknown commands
+func lookupCommand(command string) (commandHandler, role) {
 	switch command {
 	case "list", "/list", "ls", "/ls":
-		return list
+		return list, roleViewer
 
 	case "sort", "/sort", "so", "/so":
-		return sortCommand
+		// the sorting is shared by all the users
+		return sortCommand, roleOperator
 
 	case "add", "/add", "ad", "/ad":
-		return add
+		return add, roleOperator
 
 	case "search", "/search", "se", "/se":
-		return search
+		return search, roleViewer
 
 	case "info", "/info", "in", "/in":
-		return info
+		return info, roleViewer
 
 	case "stop", "/stop", "sp", "/sp", "start", "/start", "st", "/st", "check", "/check", "ck", "/ck":
-		return mainCommand
+		return mainCommand, roleOperator
 
 	case "stats", "/stats", "sa", "/sa":
-		return stats
+		return stats, roleViewer
 
 	case "progress", "/progress", "pr", "/pr":
-		return progress
+		return progress, roleViewer
 
 	case "speed", "/speed", "ss", "/ss":
-		return speed
+		return speed, roleViewer
+
+	case "stopview", "/stopview":
+		return stopView, roleViewer
+
+	case "dashboard", "/dashboard", "db", "/db":
+		return dashboard, roleViewer
+
+	case "graph", "/graph", "gr", "/gr":
+		return graph, roleViewer
 
 	case "count", "/count", "co", "/co":
-		return count
+		return count, roleViewer
+
+	case "notifications", "/notifications", "ns", "/ns", "notify", "/notify":
+		return notifications, roleViewer
+
+	case "digest", "/digest":
+		return digest, roleViewer
+
+	case "quota", "/quota":
+		return quota, roleViewer
+
+	case "rss", "/rss":
+		return rss, roleOperator
 
-	case "notifications", "/notifications", "ns", "/ns":
-		return notifications
+	case "schedule", "/schedule":
+		return schedule, roleViewer
 
 	case "del", "/del", "deldata", "/deldata":
-		return delCommand
+		return delCommand, roleAdmin
+
+	case "cleanup", "/cleanup":
+		return cleanupCommand, roleAdmin
+
+	case "action", "/action":
+		return action, roleAdmin
+
+	case "users", "/users":
+		return users, roleAdmin
+
+	case "user", "/user":
+		return userCommand, roleAdmin
+
+	case "invite", "/invite":
+		return invite, roleAdmin
+
+	case "audit", "/audit":
+		return audit, roleAdmin
 
 	case "help", "/help":
-		return help
+		return help, roleViewer
 
 	case "version", "/version":
-		return version
+		return version, roleViewer
 
 	case "":
-		return receiveTorrent
+		// plain messages are ignored unless they carry a torrent file, which needs documentRole
+		return receiveTorrent, roleViewer
 
 	default:
-		return unknownCommand
+		return nil, roleViewer
 	}
 }
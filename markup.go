@@ -0,0 +1,116 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	tagRegex    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// escape makes text safe to embed into a message sent with HTML parse mode,
+// the text itself is kept intact
+func escape(text string) string {
+	return htmlEscaper.Replace(text)
+}
+
+// plainText strips all the formatting from an HTML message, it's used when telegram
+// refuses to parse the entities of a message
+func plainText(text string) string {
+	return html.UnescapeString(tagRegex.ReplaceAllString(text, ""))
+}
+
+// isParseError checks if telegram rejected a message because of its formatting
+func isParseError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "can't parse entities")
+}
+
+const (
+	// messageLimit is the maximum length of a message text after entities parsing, in UTF-16 code units
+	messageLimit = 4096
+)
+
+var tokenRegex = regexp.MustCompile(`(?s)<[^>]*>|&#?\w+;|.`)
+
+// visibleLength returns the length of an HTML text as telegram counts it
+func visibleLength(text string) int {
+	length := 0
+	for _, r := range plainText(text) {
+		length += len(utf16.Encode([]rune{r}))
+	}
+	return length
+}
+
+// chunker accumulates an HTML text into chunks keeping formatting tags balanced in each of them
+type chunker struct {
+	chunks []string
+	buf    strings.Builder
+	length int
+	open   []string
+}
+
+func (c *chunker) write(text string) {
+	for _, tag := range tagRegex.FindAllString(text, -1) {
+		if strings.HasPrefix(tag, "</") {
+			if len(c.open) > 0 {
+				c.open = c.open[:len(c.open)-1]
+			}
+		} else {
+			c.open = append(c.open, tag)
+		}
+	}
+	c.buf.WriteString(text)
+	c.length += visibleLength(text)
+}
+
+// flush closes all the open tags, stores the chunk and reopens the tags for the next one
+func (c *chunker) flush() {
+	if c.length == 0 {
+		return
+	}
+	for i := len(c.open) - 1; i >= 0; i-- {
+		c.buf.WriteString("</" + tagName(c.open[i]) + ">")
+	}
+	c.chunks = append(c.chunks, c.buf.String())
+	c.buf.Reset()
+	c.length = 0
+	for _, tag := range c.open {
+		c.buf.WriteString(tag)
+	}
+}
+
+func tagName(tag string) string {
+	fields := strings.Fields(strings.Trim(tag, "<>/"))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// splitMessage splits an HTML text into chunks fitting into one message, it breaks the text
+// on line boundaries and falls back to splitting by characters only for too long lines
+func splitMessage(text string, limit int) []string {
+	c := &chunker{}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		length := visibleLength(line)
+		if c.length+length > limit {
+			c.flush()
+		}
+		if length <= limit {
+			c.write(line)
+			continue
+		}
+		for _, token := range tokenRegex.FindAllString(line, -1) {
+			if c.length+visibleLength(token) > limit {
+				c.flush()
+			}
+			c.write(token)
+		}
+	}
+	c.flush()
+	return c.chunks
+}
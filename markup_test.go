@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	name := "[Group] Some_Name *v2* <1080p> & more"
+	escaped := escape(name)
+	if escaped != "[Group] Some_Name *v2* &lt;1080p&gt; &amp; more" {
+		t.Fatalf("Wrong escaping: %s", escaped)
+	}
+	if plainText("<b>1</b> <code>"+escaped+"</code>") != "1 "+name {
+		t.Fatal("Name is not restored")
+	}
+}
+
+func TestSplitMessageShort(t *testing.T) {
+	chunks := splitMessage("<b>1</b> <code>name</code>\n", messageLimit)
+	if len(chunks) != 1 {
+		t.Fatalf("Expected 1 chunk, got %d", len(chunks))
+	}
+	if len(splitMessage("", messageLimit)) != 0 {
+		t.Fatal("Empty text must produce no chunks")
+	}
+}
+
+func TestSplitMessageLines(t *testing.T) {
+	line := "<b>1</b> <code>0123456789</code>\n"
+	chunks := splitMessage(strings.Repeat(line, 10), 26)
+	if len(chunks) != 5 {
+		t.Fatalf("Expected 5 chunks, got %d", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if chunk != line+line {
+			t.Fatalf("Chunk is not split on line boundary: %q", chunk)
+		}
+	}
+}
+
+func TestSplitMessageBalanced(t *testing.T) {
+	text := "<b>" + strings.Repeat("a", 10) + "\n" + strings.Repeat("&amp;", 10) + "</b>"
+	chunks := splitMessage(text, 8)
+	for _, chunk := range chunks {
+		if strings.Count(chunk, "<b>") != strings.Count(chunk, "</b>") {
+			t.Fatalf("Unbalanced chunk: %q", chunk)
+		}
+		if visibleLength(chunk) > 8 {
+			t.Fatalf("Chunk is too long: %q", chunk)
+		}
+	}
+	var joined string
+	for _, chunk := range chunks {
+		joined += plainText(chunk)
+	}
+	if joined != plainText(text) {
+		t.Fatalf("Text is lost: %q", joined)
+	}
+}
+
+func TestVisibleLength(t *testing.T) {
+	// emoji takes two UTF-16 code units
+	if l := visibleLength("<b>😀</b>&amp;"); l != 3 {
+		t.Fatalf("Wrong length: %d", l)
+	}
+}
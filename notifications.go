@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,6 +12,72 @@ import (
 	"github.com/zhulik/transmission-telegram/settings"
 )
 
+// defaultStallMinutes is used for the stalled event when the user hasn't set the period
+const defaultStallMinutes = 30
+
+// notificationEvents are the events users can subscribe to, in the order they are listed
+var notificationEvents = []string{"added", "finished", "error", "stalled", "goal", "verified", "removed"}
+
+var notificationDescriptions = map[string]string{
+	"added":    "a torrent is added by anyone",
+	"finished": "a torrent is downloaded, it's on for your own torrents unless you turn it off",
+	"error":    "a torrent gets an error",
+	"stalled":  "a download has no progress for a while",
+	"goal":     "a torrent reaches its ratio or idle seeding limit",
+	"verified": "a torrent verify is completed",
+	"removed":  "a torrent is removed",
+}
+
+// wanted reports whether the user with the preferences should be notified about the event. The finished
+// event of a torrent with a known owner goes to the owner unless it's disabled, and to the other users
+// only if they want to know about all the torrents.
+func (e torrentEvent) wanted(user int, n settings.Notifications, owner *settings.TorrentOwner) bool {
+	if !matchAnyRule(n.Rules, e.torrent) {
+		return false
+	}
+	if e.kind == "finished" && owner != nil {
+		if owner.UserID == user {
+			return !n.Disabled(e.kind)
+		}
+		return n.AllTorrents && n.Enabled(e.kind)
+	}
+	if !n.Enabled(e.kind) {
+		return false
+	}
+	if e.kind == "stalled" {
+		limit := time.Duration(n.StallMinutes) * time.Minute
+		if limit == 0 {
+			limit = defaultStallMinutes * time.Minute
+		}
+		return e.stalledBefore < limit && e.stalled >= limit
+	}
+	return true
+}
+
+func (e torrentEvent) message() string {
+	name := fmt.Sprintf("<b>%d</b> <code>%s</code>", e.torrent.ID, escape(ellipsisString(e.torrent.Name, 25)))
+	switch e.kind {
+	case "added":
+		return name + " is added"
+	case "finished":
+		if e.offline {
+			return name + " is finished while the bot was offline!"
+		}
+		return name + " is finished!"
+	case "error":
+		return fmt.Sprintf("%s has an error: <i>%s</i>", name, escape(e.torrent.ErrorString))
+	case "stalled":
+		return fmt.Sprintf("%s has no progress for <b>%s</b>", name, e.stalled.Truncate(time.Minute))
+	case "goal":
+		return fmt.Sprintf("%s has reached its seeding goal, ratio <b>%s</b>", name, e.torrent.Ratio())
+	case "verified":
+		return fmt.Sprintf("%s is verified, <b>%.1f%%</b> is valid", name, e.torrent.PercentDone*100)
+	case "removed":
+		return name + " is removed"
+	}
+	return name + " " + e.kind
+}
+
 func findFinished(before transmission.Torrents, after transmission.Torrents) (result transmission.Torrents) {
 	for _, aT := range after {
 		for _, bT := range before {
@@ -21,74 +89,322 @@ func findFinished(before transmission.Torrents, after transmission.Torrents) (re
 	return
 }
 
-func sendFinishedTorrent(bot telegramClient, t *transmission.Torrent, chatID int64) {
-	msg := fmt.Sprintf("*%d* `%s` is finished!", t.ID, ellipsisString(t.Name, 25))
-	send(bot, msg, chatID, true)
-	log.Println("Finished torrent was sent")
+// notifyEvents notifies the users about the events of the torrents they are subscribed to
+func notifyEvents(bot telegramClient, client torrentClient, sub *subscription, auth *authorizer, s settings.Settings) {
+	n := newNotifier(bot, s)
+	go n.deliverQueued(auth)
+
+	states := newTorrentStates(client, s)
+	for update := range sub.C {
+		if update.err != nil {
+			continue
+		}
+		if len(update.events) > 0 {
+			notifyUsers(n, update.events, states, auth, s)
+		}
+		if err := states.save(update.torrents, time.Now()); err != nil {
+			log.Println("Saving torrent states failed:", err.Error())
+		}
+	}
 }
 
-func notifyFinished(bot telegramClient, client torrentClient, masters []string, s settings.Settings) {
+func notifyUsers(n *notifier, events []torrentEvent, states *torrentStates, auth *authorizer, s settings.Settings) {
 	var torrents transmission.Torrents
-	for {
-		newTorrents, err := client.GetTorrents()
+	for _, e := range events {
+		torrents = append(torrents, e.torrent)
+	}
+	if err := states.updateHashes(torrents); err != nil {
+		log.Println("Getting torrent hashes failed:", err.Error())
+	}
+	owners, err := s.GetTorrentOwners()
+	if err != nil {
+		log.Println("GetTorrentOwners failed:", err.Error())
+	}
+	ownerOf := func(e torrentEvent) *settings.TorrentOwner {
+		if owner, ok := owners[states.hash(e.torrent.ID)]; ok {
+			return &owner
+		}
+		return nil
+	}
+
+	for _, user := range auth.userIDs() {
+		prefs, err := s.GetUserNotifications(strconv.Itoa(user))
 		if err != nil {
-			log.Println("GetTorrents failed:", err.Error())
+			log.Println("GetUserNotifications failed:", err.Error())
 			continue
 		}
+		for _, e := range events {
+			if e.wanted(user, prefs, ownerOf(e)) {
+				n.notify(user, prefs, e.message())
+			}
+		}
+	}
 
-		for _, t := range findFinished(torrents, newTorrents) {
-			for _, master := range masters {
-				notify, err := s.GetUserNotification(master)
-				if err != nil {
-					log.Println("GetUserNotification failed:", err.Error())
-					continue
-				}
-				if notify {
-					id, err := s.GetUserID(master)
-					if err != nil {
-						log.Println("GetUserID failed:", err.Error())
-						continue
-					}
-					go sendFinishedTorrent(bot, t, id)
-				}
+	for _, e := range events {
+		if e.kind == "removed" && ownerOf(e) != nil {
+			if err := s.DeleteTorrentOwner(states.hash(e.torrent.ID)); err != nil {
+				log.Println("DeleteTorrentOwner failed:", err.Error())
 			}
+		}
+	}
+}
 
+func describeNotifications(n settings.Notifications) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("<b>notifications</b>:\n")
+	for _, event := range notificationEvents {
+		state := "off"
+		if n.Enabled(event) {
+			state = "on"
+		}
+		buf.WriteString(fmt.Sprintf("<b>%s</b> %s - <i>%s</i>", event, state, notificationDescriptions[event]))
+		if event == "stalled" {
+			minutes := n.StallMinutes
+			if minutes == 0 {
+				minutes = defaultStallMinutes
+			}
+			buf.WriteString(fmt.Sprintf(", %d minutes", minutes))
 		}
-		torrents = newTorrents
-		time.Sleep(time.Second * interval)
+		buf.WriteString("\n")
+	}
+	if n.Quiet {
+		buf.WriteString(fmt.Sprintf("\n<b>quiet hours</b> %s", formatQuietHours(n)))
+	} else {
+		buf.WriteString("\n<b>quiet hours</b> off")
+	}
+	buf.WriteString(fmt.Sprintf("\n<b>time zone</b> %s\n", escape(location(n).String())))
+	if n.AllTorrents {
+		buf.WriteString("<b>scope</b> all, finished torrents of everyone are notified\n")
+	} else {
+		buf.WriteString("<b>scope</b> mine, finished torrents added by others are not notified\n")
+	}
+	if len(n.Rules) > 0 {
+		buf.WriteString(fmt.Sprintf("<b>rules</b>: only torrents matching %d rules\n", len(n.Rules)))
 	}
+	return buf.String()
 }
 
-func notifications(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	if len(ud.Tokens()) == 0 {
-		b, err := s.GetUserNotification(ud.Chat.UserName)
-		if err != nil {
-			send(bot, fmt.Sprintf("*notifications*: error get settings: %s", err.Error()), ud.Chat.ID, true)
+func formatQuietHours(n settings.Notifications) string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", n.QuietStart/60, n.QuietStart%60, n.QuietEnd/60, n.QuietEnd%60)
+}
+
+// quietHours sets the quiet hours: notifications quiet 23:00-08:00 or notifications quiet off
+func quietHours(bot telegramClient, ud messageWrapper, s settings.Settings, key string, prefs settings.Notifications, tokens []string) {
+	if len(tokens) == 0 {
+		send(bot, "<b>notifications</b>: needs quiet hours like <code>23:00-08:00</code> or <i>off</i>", ud.Chat.ID, ud.Role())
+		return
+	}
+
+	if on, ok := parseSwitch(tokens[0]); ok && !on {
+		prefs.Quiet = false
+	} else {
+		bounds := strings.Split(tokens[0], "-")
+		if len(bounds) != 2 {
+			send(bot, fmt.Sprintf("<b>notifications</b>: wrong quiet hours <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
 			return
 		}
-		if b {
-			send(bot, "*notifications* is enabled", ud.Chat.ID, true)
-		} else {
-			send(bot, "*notifications* is disabled", ud.Chat.ID, true)
+		startHour, startMinute, ok := parseClock(bounds[0])
+		endHour, endMinute, ok2 := parseClock(bounds[1])
+		if !ok || !ok2 {
+			send(bot, fmt.Sprintf("<b>notifications</b>: wrong quiet hours <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+			return
 		}
+		prefs.Quiet = true
+		prefs.QuietStart = startHour*60 + startMinute
+		prefs.QuietEnd = endHour*60 + endMinute
+	}
+
+	if err := s.SetUserNotifications(key, prefs); err != nil {
+		send(bot, fmt.Sprintf("<b>notifications</b>: error save settings: %s", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	if prefs.Quiet {
+		send(bot, fmt.Sprintf("<b>notifications</b>: quiet hours are %s %s", formatQuietHours(prefs), escape(location(prefs).String())), ud.Chat.ID, ud.Role())
+	} else {
+		send(bot, "<b>notifications</b>: quiet hours disabled", ud.Chat.ID, ud.Role())
+	}
+}
+
+// timeZone sets the time zone of quiet hours and digests: notifications timezone Europe/Berlin
+func timeZone(bot telegramClient, ud messageWrapper, s settings.Settings, key string, prefs settings.Notifications, tokens []string) {
+	if len(tokens) == 0 {
+		send(bot, fmt.Sprintf("<b>notifications</b>: time zone is %s", escape(location(prefs).String())), ud.Chat.ID, ud.Role())
 		return
 	}
-	switch strings.ToLower(ud.Tokens()[0]) {
+	if _, err := time.LoadLocation(tokens[0]); err != nil {
+		send(bot, fmt.Sprintf("<b>notifications</b>: wrong time zone <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+		return
+	}
+	prefs.TimeZone = tokens[0]
+	if err := s.SetUserNotifications(key, prefs); err != nil {
+		send(bot, fmt.Sprintf("<b>notifications</b>: error save settings: %s", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, fmt.Sprintf("<b>notifications</b>: time zone is %s", escape(tokens[0])), ud.Chat.ID, ud.Role())
+}
+
+func parseSwitch(value string) (bool, bool) {
+	switch strings.ToLower(value) {
 	case "on", "true", "enable":
-		err := s.SetUserNotification(ud.Chat.UserName, true)
-		if err != nil {
-			send(bot, fmt.Sprintf("*notifications*: error save settings: %s", err.Error()), ud.Chat.ID, true)
+		return true, true
+	case "off", "false", "disable":
+		return false, true
+	}
+	return false, false
+}
+
+// notifications shows or changes the notification preferences:
+// notifications on|off toggles the finished event, notifications <event|all> on|off toggles the event,
+// notifications stalled on [minutes] also sets the stall period
+func notifications(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	key := strconv.Itoa(ud.From.ID)
+	prefs, err := s.GetUserNotifications(key)
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>notifications</b>: error get settings: %s", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	tokens := ud.Tokens()
+	if len(tokens) == 0 {
+		send(bot, describeNotifications(prefs), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	switch strings.ToLower(tokens[0]) {
+	case "quiet":
+		quietHours(bot, ud, s, key, prefs, tokens[1:])
+		return
+	case "timezone", "tz":
+		timeZone(bot, ud, s, key, prefs, tokens[1:])
+		return
+	case "rule", "rules":
+		notificationRules(bot, ud, s, key, prefs, tokens[1:])
+		return
+	case "scope":
+		notificationScope(bot, ud, s, key, prefs, tokens[1:])
+		return
+	}
+
+	events := []string{"finished"}
+	if _, ok := parseSwitch(tokens[0]); !ok {
+		event := strings.ToLower(tokens[0])
+		if event == "all" {
+			events = notificationEvents
+		} else if _, ok := notificationDescriptions[event]; ok {
+			events = []string{event}
+		} else {
+			send(bot, fmt.Sprintf("<b>notifications</b>: Unknown argument <code>%s</code>", escape(ud.CommandArguments())), ud.Chat.ID, ud.Role())
 			return
 		}
-		send(bot, "*notifications*: notifications enabled", ud.Chat.ID, true)
-	case "off", "false", "disable":
-		err := s.SetUserNotification(ud.Chat.UserName, false)
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) == 0 {
+		send(bot, "<b>notifications</b>: needs <i>on</i> or <i>off</i>", ud.Chat.ID, ud.Role())
+		return
+	}
+	on, ok := parseSwitch(tokens[0])
+	if !ok {
+		send(bot, fmt.Sprintf("<b>notifications</b>: Unknown argument <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+		return
+	}
+	if len(tokens) > 1 && len(events) == 1 && events[0] == "stalled" {
+		minutes, err := strconv.Atoi(tokens[1])
+		if err != nil || minutes <= 0 {
+			send(bot, fmt.Sprintf("<b>notifications</b>: wrong number of minutes <code>%s</code>", escape(tokens[1])), ud.Chat.ID, ud.Role())
+			return
+		}
+		prefs.StallMinutes = minutes
+	}
+
+	for _, event := range events {
+		prefs.Events[event] = on
+	}
+	if err := s.SetUserNotifications(key, prefs); err != nil {
+		send(bot, fmt.Sprintf("<b>notifications</b>: error save settings: %s", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	state := "disabled"
+	if on {
+		state = "enabled"
+	}
+	send(bot, fmt.Sprintf("<b>notifications</b>: %s %s", strings.Join(events, ", "), state), ud.Chat.ID, ud.Role())
+}
+
+// notificationRules manages the rules: notify rule add name:/S\d\dE\d\d/, notify rule list, notify rule rm 1
+func notificationRules(bot telegramClient, ud messageWrapper, s settings.Settings, key string, prefs settings.Notifications, tokens []string) {
+	command := "list"
+	if len(tokens) > 0 {
+		command = strings.ToLower(tokens[0])
+		tokens = tokens[1:]
+	}
+
+	switch command {
+	case "list", "ls":
+		if len(prefs.Rules) == 0 {
+			send(bot, "<b>notifications</b>: no rules, all the torrents are notified", ud.Chat.ID, ud.Role())
+			return
+		}
+		buf := new(bytes.Buffer)
+		buf.WriteString("<b>notifications</b> only for torrents matching:\n")
+		for i, rule := range prefs.Rules {
+			buf.WriteString(fmt.Sprintf("<b>%d</b> <code>%s</code>\n", i+1, escape(rule)))
+		}
+		send(bot, buf.String(), ud.Chat.ID, ud.Role())
+		return
+	case "add":
+		if len(tokens) == 0 {
+			send(bot, "<b>notifications</b>: needs a rule like <code>tracker:example.org</code>, <code>name:/S\\d\\dE\\d\\d/</code> or <code>dir:/downloads/tv</code>", ud.Chat.ID, ud.Role())
+			return
+		}
+		rule, err := parseTorrentRule(strings.Join(tokens, " "))
 		if err != nil {
-			send(bot, fmt.Sprintf("*notifications*: error save settings: %s", err.Error()), ud.Chat.ID, true)
+			send(bot, fmt.Sprintf("<b>notifications</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			return
+		}
+		prefs.Rules = append(prefs.Rules, rule.String())
+	case "rm", "remove", "del":
+		if len(tokens) == 0 {
+			send(bot, "<b>notifications</b>: needs a rule number", ud.Chat.ID, ud.Role())
 			return
 		}
-		send(bot, "*notifications*: notifications disabled", ud.Chat.ID, true)
+		n, err := strconv.Atoi(tokens[0])
+		if err != nil || n < 1 || n > len(prefs.Rules) {
+			send(bot, fmt.Sprintf("<b>notifications</b>: no rule <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+			return
+		}
+		prefs.Rules = append(prefs.Rules[:n-1], prefs.Rules[n:]...)
 	default:
-		send(bot, fmt.Sprintf("*notifications*: Unknown argument `%s`", ud.CommandArguments()), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>notifications</b>: Unknown argument <code>%s</code>, use add, list or rm", escape(command)), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	if err := s.SetUserNotifications(key, prefs); err != nil {
+		send(bot, fmt.Sprintf("<b>notifications</b>: error save settings: %s", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, fmt.Sprintf("<b>notifications</b>: %d rules", len(prefs.Rules)), ud.Chat.ID, ud.Role())
+}
+
+// notificationScope sets whose finished torrents are notified: notifications scope mine|all
+func notificationScope(bot telegramClient, ud messageWrapper, s settings.Settings, key string, prefs settings.Notifications, tokens []string) {
+	if len(tokens) == 0 {
+		send(bot, "<b>notifications</b>: needs <i>mine</i> or <i>all</i>", ud.Chat.ID, ud.Role())
+		return
+	}
+	switch strings.ToLower(tokens[0]) {
+	case "mine":
+		prefs.AllTorrents = false
+	case "all":
+		prefs.AllTorrents = true
+	default:
+		send(bot, fmt.Sprintf("<b>notifications</b>: Unknown argument <code>%s</code>, use mine or all", escape(tokens[0])), ud.Chat.ID, ud.Role())
+		return
+	}
+	if err := s.SetUserNotifications(key, prefs); err != nil {
+		send(bot, fmt.Sprintf("<b>notifications</b>: error save settings: %s", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
 	}
+	send(bot, fmt.Sprintf("<b>notifications</b>: scope is %s", strings.ToLower(tokens[0])), ud.Chat.ID, ud.Role())
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+func TestInQuietHours(t *testing.T) {
+	overnight := settings.Notifications{Quiet: true, QuietStart: 23 * 60, QuietEnd: 8 * 60, TimeZone: "UTC"}
+	daytime := settings.Notifications{Quiet: true, QuietStart: 9 * 60, QuietEnd: 18 * 60, TimeZone: "UTC"}
+
+	cases := []struct {
+		prefs settings.Notifications
+		hour  int
+		quiet bool
+	}{
+		{overnight, 23, true},
+		{overnight, 3, true},
+		{overnight, 8, false},
+		{overnight, 12, false},
+		{daytime, 12, true},
+		{daytime, 20, false},
+		{settings.Notifications{TimeZone: "UTC"}, 3, false},
+	}
+	for _, c := range cases {
+		if quiet := inQuietHours(c.prefs, time.Date(2020, time.March, 4, c.hour, 30, 0, 0, time.UTC)); quiet != c.quiet {
+			t.Errorf("inQuietHours(%+v) at %d:30 = %v", c.prefs, c.hour, quiet)
+		}
+	}
+}
+
+func TestNotifierBatchesAndQueues(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	fake := &fakeTelegramClient{}
+	n := newNotifier(fake, s)
+	n.now = func() time.Time { return time.Date(2020, time.March, 4, 12, 30, 0, 0, time.UTC) }
+	// the window is closed by the test
+	n.window = time.Hour
+
+	n.notify(1, settings.Notifications{}, "first")
+	if texts := fake.texts(); len(texts) != 1 || texts[0] != "first" {
+		t.Fatalf("The first notification is not sent at once: %v", texts)
+	}
+	n.notify(1, settings.Notifications{}, "second")
+	n.notify(1, settings.Notifications{}, "third")
+	if texts := fake.texts(); len(texts) != 1 {
+		t.Fatalf("The notifications within the window are not grouped: %v", texts)
+	}
+	n.flush(1, n.batches[1])
+	if texts := fake.texts(); len(texts) != 2 || texts[1] != "second\nthird\n" {
+		t.Fatalf("Wrong messages are sent: %v", texts)
+	}
+
+	quiet := settings.Notifications{Quiet: true, QuietStart: 12 * 60, QuietEnd: 13 * 60, TimeZone: "UTC"}
+	n.notify(2, quiet, "quiet")
+	queued, err := s.TakeQueuedNotifications("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0] != "quiet" {
+		t.Fatalf("Wrong queued notifications: %v", queued)
+	}
+}
+
+func TestFinishedEventOwner(t *testing.T) {
+	e := torrentEvent{kind: "finished", torrent: &transmission.Torrent{ID: 1, Name: "torrent"}}
+	owner := &settings.TorrentOwner{UserID: 1}
+	none := settings.Notifications{Events: map[string]bool{}}
+	enabled := settings.Notifications{Events: map[string]bool{"finished": true}}
+	disabled := settings.Notifications{Events: map[string]bool{"finished": false}}
+	everyone := settings.Notifications{Events: map[string]bool{"finished": true}, AllTorrents: true}
+
+	cases := []struct {
+		user     int
+		prefs    settings.Notifications
+		owner    *settings.TorrentOwner
+		expected bool
+	}{
+		{1, none, owner, true},
+		{1, disabled, owner, false},
+		{2, enabled, owner, false},
+		{2, everyone, owner, true},
+		{2, enabled, nil, true},
+		{2, none, nil, false},
+	}
+	for i, c := range cases {
+		if e.wanted(c.user, c.prefs, c.owner) != c.expected {
+			t.Errorf("Case %d: wanted is %v", i, !c.expected)
+		}
+	}
+}
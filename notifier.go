@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+const (
+	// notificationBatchWindow is how long notifications are collected to be sent as one message
+	notificationBatchWindow = 30 * time.Second
+	// quietCheckInterval is how often queued notifications are checked for delivery
+	quietCheckInterval = time.Minute
+)
+
+// location returns the time zone of the user, the local one if it's not set
+func location(n settings.Notifications) *time.Location {
+	if n.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(n.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// inQuietHours reports whether the time is in the quiet hours of the user, the hours may span midnight
+func inQuietHours(n settings.Notifications, t time.Time) bool {
+	if !n.Quiet || n.QuietStart == n.QuietEnd {
+		return false
+	}
+	t = t.In(location(n))
+	minute := t.Hour()*60 + t.Minute()
+	if n.QuietStart < n.QuietEnd {
+		return minute >= n.QuietStart && minute < n.QuietEnd
+	}
+	return minute >= n.QuietStart || minute < n.QuietEnd
+}
+
+func combineNotifications(title string, texts []string) string {
+	if len(texts) == 1 && title == "" {
+		return texts[0]
+	}
+	buf := new(bytes.Buffer)
+	if title != "" {
+		buf.WriteString(title + "\n")
+	}
+	for _, text := range texts {
+		buf.WriteString(text + "\n")
+	}
+	return buf.String()
+}
+
+// notifier delivers notifications to users, the first notification is sent at once and the following ones
+// are grouped until notificationBatchWindow passes, notifications are queued in the settings during quiet hours
+type notifier struct {
+	bot telegramClient
+	s   settings.Settings
+	// now and window are time.Now and notificationBatchWindow, tests replace them
+	now    func() time.Time
+	window time.Duration
+
+	mu sync.Mutex
+	// batches are the open windows of the users
+	batches map[int]*notificationBatch
+}
+
+// notificationBatch collects the notifications of a user sent within the window
+type notificationBatch struct {
+	texts []string
+}
+
+func newNotifier(bot telegramClient, s settings.Settings) *notifier {
+	return &notifier{bot: bot, s: s, now: time.Now, window: notificationBatchWindow, batches: make(map[int]*notificationBatch)}
+}
+
+// notify sends the text to the user
+func (n *notifier) notify(user int, prefs settings.Notifications, text string) {
+	if inQuietHours(prefs, n.now()) {
+		if err := n.s.QueueNotification(strconv.Itoa(user), text); err != nil {
+			log.Println("QueueNotification failed:", err.Error())
+		}
+		return
+	}
+
+	n.mu.Lock()
+	if b, ok := n.batches[user]; ok {
+		b.texts = append(b.texts, text)
+		n.mu.Unlock()
+		return
+	}
+	b := &notificationBatch{}
+	n.batches[user] = b
+	n.mu.Unlock()
+
+	time.AfterFunc(n.window, func() { n.flush(user, b) })
+	n.deliver(user, []string{text})
+}
+
+// flush closes the window of the user and sends the notifications collected in it as one message
+func (n *notifier) flush(user int, b *notificationBatch) {
+	n.mu.Lock()
+	if n.batches[user] != b {
+		n.mu.Unlock()
+		return
+	}
+	delete(n.batches, user)
+	texts := b.texts
+	n.mu.Unlock()
+	if len(texts) > 0 {
+		n.deliver(user, texts)
+	}
+}
+
+// deliver sends the notifications to the user, or queues them if the quiet hours have started
+func (n *notifier) deliver(user int, texts []string) {
+	key := strconv.Itoa(user)
+	prefs, err := n.s.GetUserNotifications(key)
+	if err == nil && inQuietHours(prefs, n.now()) {
+		for _, text := range texts {
+			if err := n.s.QueueNotification(key, text); err != nil {
+				log.Println("QueueNotification failed:", err.Error())
+			}
+		}
+		return
+	}
+
+	// the private chat with a user has the same ID as the user
+	send(n.bot, combineNotifications("", texts), int64(user), roleNone)
+	log.Printf("%d notifications were sent", len(texts))
+}
+
+// deliverQueued sends the notifications queued during quiet hours once they are over
+func (n *notifier) deliverQueued(auth *authorizer) {
+	for {
+		time.Sleep(quietCheckInterval)
+
+		for _, user := range auth.userIDs() {
+			key := strconv.Itoa(user)
+			prefs, err := n.s.GetUserNotifications(key)
+			if err != nil {
+				log.Println("GetUserNotifications failed:", err.Error())
+				continue
+			}
+			if inQuietHours(prefs, n.now()) {
+				continue
+			}
+			texts, err := n.s.TakeQueuedNotifications(key)
+			if err != nil {
+				log.Println("TakeQueuedNotifications failed:", err.Error())
+				continue
+			}
+			if len(texts) > 0 {
+				send(n.bot, combineNotifications(fmt.Sprintf("<b>During quiet hours</b>, %d notifications:", len(texts)), texts), int64(user), roleNone)
+			}
+		}
+	}
+}
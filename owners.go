@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+// ownerName returns the name of the user who added a torrent
+func ownerName(owner settings.TorrentOwner) string {
+	if owner.Username != "" {
+		return "@" + owner.Username
+	}
+	return strconv.Itoa(owner.UserID)
+}
+
+// recordOwner stores the user as the owner of the added torrent, the owner of a torrent added again doesn't change
+func recordOwner(s settings.Settings, torrent transmission.TorrentAdded, userID int, username string) {
+	if torrent.HashString == "" || userID == 0 {
+		return
+	}
+	owner, err := s.GetTorrentOwner(torrent.HashString)
+	if err != nil {
+		log.Println("GetTorrentOwner failed:", err.Error())
+		return
+	}
+	if owner != nil {
+		return
+	}
+	err = s.SetTorrentOwner(torrent.HashString, settings.TorrentOwner{UserID: userID, Username: username, Added: time.Now()})
+	if err != nil {
+		log.Println("SetTorrentOwner failed:", err.Error())
+	}
+}
+
+// torrentOwners returns the owners of the torrents by torrent IDs, torrents added outside of the bot have no owners
+func torrentOwners(client torrentClient, s settings.Settings) (map[int]settings.TorrentOwner, error) {
+	owners, err := s.GetTorrentOwners()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]settings.TorrentOwner)
+	if len(owners) == 0 {
+		return result, nil
+	}
+
+	details, err := client.GetTorrentDetails()
+	if err != nil {
+		return nil, err
+	}
+	for id, d := range details {
+		if owner, ok := owners[d.HashString]; ok {
+			result[id] = owner
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pyed/transmission"
+)
+
+// fullPollInterval is how often all the torrents are fetched, the polls in between
+// fetch only the recently active ones
+const fullPollInterval = time.Minute
+
+// pollUpdate is published to the subscribers after every poll
+type pollUpdate struct {
+	// torrents are all the torrents ordered by ID
+	torrents transmission.Torrents
+	events   []torrentEvent
+	// err is set when the poll failed, the torrents are the ones polled before then
+	err error
+}
+
+// subscription receives the updates of torrentPoller, an update replaces the unread one
+// keeping its events, so slow subscribers get the latest torrents and all the events
+type subscription struct {
+	C chan pollUpdate
+}
+
+func (s *subscription) publish(u pollUpdate) {
+	select {
+	case s.C <- u:
+		return
+	default:
+	}
+	select {
+	case old := <-s.C:
+		u.events = append(old.events, u.events...)
+	default:
+	}
+	// the poller is the only sender, so there is room now
+	s.C <- u
+}
+
+// torrentPoller is the only component polling the torrents, it publishes them
+// along with the events found by comparing the polls to the subscribers
+type torrentPoller struct {
+	client  torrentClient
+	watcher *torrentWatcher
+	// states find the torrents finished while the bot wasn't running, they are checked at the first poll
+	states *torrentStates
+
+	mu          sync.Mutex
+	torrents    map[int]*transmission.Torrent
+	last        *pollUpdate
+	polledAt    time.Time
+	subscribers map[*subscription]bool
+}
+
+// poller is shared by notifications, live views and dashboards, it's started by main
+var poller *torrentPoller
+
+func newTorrentPoller(client torrentClient) *torrentPoller {
+	return &torrentPoller{client: client, watcher: newTorrentWatcher(), subscribers: make(map[*subscription]bool)}
+}
+
+// subscribe returns a subscription which receives the latest torrents at once if they are polled already
+func (p *torrentPoller) subscribe() *subscription {
+	sub := &subscription{C: make(chan pollUpdate, 1)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subscribers[sub] = true
+	if p.last != nil {
+		sub.C <- pollUpdate{torrents: p.last.torrents}
+	}
+	return sub
+}
+
+func (p *torrentPoller) unsubscribe(sub *subscription) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.subscribers, sub)
+}
+
+// run polls the torrents every interval
+func (p *torrentPoller) run() {
+	for {
+		if err := p.poll(time.Now()); err != nil {
+			log.Println("Polling torrents failed:", err.Error())
+		}
+		time.Sleep(time.Second * interval)
+	}
+}
+
+// fetch returns all the torrents, only the changed ones are fetched when possible
+func (p *torrentPoller) fetch(now time.Time) (map[int]*transmission.Torrent, error) {
+	if p.torrents != nil && now.Sub(p.polledAt) < fullPollInterval {
+		active, removed, err := p.client.GetRecentlyActive()
+		if err == nil {
+			torrents := make(map[int]*transmission.Torrent, len(p.torrents))
+			for id, t := range p.torrents {
+				torrents[id] = t
+			}
+			for _, t := range active {
+				torrents[t.ID] = t
+			}
+			for _, id := range removed {
+				delete(torrents, id)
+			}
+			return torrents, nil
+		}
+		log.Println("GetRecentlyActive failed:", err.Error())
+	}
+
+	list, err := p.client.GetTorrents()
+	if err != nil {
+		return nil, err
+	}
+	torrents := make(map[int]*transmission.Torrent, len(list))
+	for _, t := range list {
+		torrents[t.ID] = t
+	}
+	p.polledAt = now
+	return torrents, nil
+}
+
+func (p *torrentPoller) poll(now time.Time) error {
+	torrents, err := p.fetch(now)
+	if err != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		update := pollUpdate{err: err}
+		if p.last != nil {
+			update.torrents = p.last.torrents
+		}
+		for sub := range p.subscribers {
+			sub.publish(update)
+		}
+		return err
+	}
+	p.torrents = torrents
+
+	list := make(transmission.Torrents, 0, len(torrents))
+	for _, t := range torrents {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	update := pollUpdate{torrents: list, events: p.watcher.update(list, now)}
+	if p.states != nil {
+		missed, err := p.states.missed(list)
+		if err != nil {
+			log.Println("Loading torrent states failed:", err.Error())
+		}
+		for _, t := range missed {
+			update.events = append(update.events, torrentEvent{kind: "finished", torrent: t, offline: true})
+		}
+		p.states = nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = &update
+	for sub := range p.subscribers {
+		sub.publish(update)
+	}
+	return nil
+}
+
+// torrentEvent is a change of a torrent found by torrentWatcher, the kinds are added, removed,
+// status, progress, finished, error, goal, verified and stalled
+type torrentEvent struct {
+	kind    string
+	torrent *transmission.Torrent
+	// before is the previous state of status and progress events
+	before *transmission.Torrent
+	// stalled events have the time without progress at the previous and the current poll
+	stalledBefore time.Duration
+	stalled       time.Duration
+	// offline events happened while the bot wasn't running
+	offline bool
+}
+
+// torrentWatcher compares successive lists of torrents and reports the events
+type torrentWatcher struct {
+	torrents     map[int]*transmission.Torrent
+	progressedAt map[int]time.Time
+	// initialCheck has the new torrents checked since they were added, the check is not a verification
+	initialCheck map[int]bool
+	polledAt     time.Time
+}
+
+func newTorrentWatcher() *torrentWatcher {
+	return &torrentWatcher{progressedAt: make(map[int]time.Time), initialCheck: make(map[int]bool)}
+}
+
+func isChecking(t *transmission.Torrent) bool {
+	return t.Status == transmission.StatusChecking || t.Status == transmission.StatusCheckPending
+}
+
+// update stores the torrents and returns the events since the previous update,
+// the first update only remembers the torrents
+func (w *torrentWatcher) update(torrents transmission.Torrents, now time.Time) (events []torrentEvent) {
+	current := make(map[int]*transmission.Torrent, len(torrents))
+	for _, t := range torrents {
+		current[t.ID] = t
+	}
+	first := w.torrents == nil
+
+	for _, t := range torrents {
+		before, ok := w.torrents[t.ID]
+		if !ok {
+			w.progressedAt[t.ID] = now
+			if !first {
+				events = append(events, torrentEvent{kind: "added", torrent: t})
+				if isChecking(t) {
+					w.initialCheck[t.ID] = true
+				}
+			}
+			continue
+		}
+
+		if t.Status != before.Status {
+			events = append(events, torrentEvent{kind: "status", torrent: t, before: before})
+		}
+		if t.PercentDone != before.PercentDone {
+			events = append(events, torrentEvent{kind: "progress", torrent: t, before: before})
+		}
+		if len(findFinished(transmission.Torrents{before}, transmission.Torrents{t})) > 0 {
+			events = append(events, torrentEvent{kind: "finished", torrent: t})
+		}
+		if t.Error != 0 && before.Error == 0 {
+			events = append(events, torrentEvent{kind: "error", torrent: t})
+		}
+		if t.IsFinished && !before.IsFinished {
+			events = append(events, torrentEvent{kind: "goal", torrent: t})
+		}
+		if isChecking(before) && !isChecking(t) {
+			if !w.initialCheck[t.ID] {
+				events = append(events, torrentEvent{kind: "verified", torrent: t})
+			}
+			delete(w.initialCheck, t.ID)
+		}
+
+		if t.Status != transmission.StatusDownloading || t.DownloadedEver != before.DownloadedEver {
+			w.progressedAt[t.ID] = now
+		} else if since, ok := w.progressedAt[t.ID]; ok {
+			events = append(events, torrentEvent{kind: "stalled", torrent: t, stalledBefore: w.polledAt.Sub(since), stalled: now.Sub(since)})
+		}
+	}
+
+	for id, t := range w.torrents {
+		if _, ok := current[id]; !ok {
+			delete(w.progressedAt, id)
+			delete(w.initialCheck, id)
+			events = append(events, torrentEvent{kind: "removed", torrent: t})
+		}
+	}
+
+	w.torrents = current
+	w.polledAt = now
+	return
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+func eventKinds(events []torrentEvent) (kinds []string) {
+	for _, e := range events {
+		kinds = append(kinds, e.kind)
+	}
+	return
+}
+
+func TestTorrentWatcher(t *testing.T) {
+	w := newTorrentWatcher()
+	start := time.Unix(1000, 0)
+
+	events := w.update(transmission.Torrents{
+		{ID: 1, Status: transmission.StatusDownloading, DownloadedEver: 10},
+		{ID: 2, Status: transmission.StatusChecking},
+		{ID: 3, Status: transmission.StatusSeeding},
+	}, start)
+	if len(events) != 0 {
+		t.Fatalf("First update returned events: %v", eventKinds(events))
+	}
+
+	events = w.update(transmission.Torrents{
+		{ID: 1, Status: transmission.StatusSeeding, DownloadedEver: 20},
+		{ID: 2, Status: transmission.StatusStopped, Error: 3, ErrorString: "No data found"},
+		{ID: 4, Status: transmission.StatusDownloading},
+	}, start.Add(time.Minute))
+	expected := map[string]int{"finished": 1, "error": 2, "verified": 2, "added": 4, "removed": 3}
+	var notified []torrentEvent
+	for _, e := range events {
+		if e.kind != "status" && e.kind != "progress" {
+			notified = append(notified, e)
+		}
+	}
+	if len(notified) != len(expected) {
+		t.Fatalf("Wrong events: %v", eventKinds(events))
+	}
+	for _, e := range notified {
+		if expected[e.kind] != e.torrent.ID {
+			t.Errorf("Wrong torrent %d of %s event", e.torrent.ID, e.kind)
+		}
+	}
+}
+
+func TestVerifiedEventOfNewTorrent(t *testing.T) {
+	w := newTorrentWatcher()
+	start := time.Unix(1000, 0)
+	w.update(transmission.Torrents{}, start)
+
+	// a new torrent is checked once it's added, that's not a verification
+	w.update(transmission.Torrents{{ID: 1, Status: transmission.StatusChecking}}, start.Add(time.Minute))
+	events := w.update(transmission.Torrents{{ID: 1, Status: transmission.StatusDownloading}}, start.Add(2*time.Minute))
+	for _, e := range events {
+		if e.kind == "verified" {
+			t.Fatal("The initial check is reported as verified")
+		}
+	}
+
+	w.update(transmission.Torrents{{ID: 1, Status: transmission.StatusChecking}}, start.Add(3*time.Minute))
+	events = w.update(transmission.Torrents{{ID: 1, Status: transmission.StatusDownloading}}, start.Add(4*time.Minute))
+	var verified bool
+	for _, e := range events {
+		verified = verified || e.kind == "verified"
+	}
+	if !verified {
+		t.Fatalf("The later check is not reported: %v", eventKinds(events))
+	}
+}
+
+func TestStalledEvent(t *testing.T) {
+	w := newTorrentWatcher()
+	start := time.Unix(1000, 0)
+	prefs := settings.Notifications{Events: map[string]bool{"stalled": true}, StallMinutes: 10}
+
+	notified := 0
+	for i := 0; i <= 30; i++ {
+		events := w.update(transmission.Torrents{{ID: 1, Status: transmission.StatusDownloading, DownloadedEver: 10}}, start.Add(time.Duration(i)*time.Minute))
+		for _, e := range events {
+			if e.wanted(1, prefs, nil) {
+				notified++
+				if e.stalled != 10*time.Minute {
+					t.Errorf("Stalled event after %s", e.stalled)
+				}
+			}
+		}
+	}
+	if notified != 1 {
+		t.Fatalf("Stalled torrent is notified %d times", notified)
+	}
+}
+
+func TestTorrentPollerIncremental(t *testing.T) {
+	client := &fakeTorrentClient{torrents: transmission.Torrents{
+		{ID: 2, Status: transmission.StatusDownloading, PercentDone: 0.5},
+		{ID: 1, Status: transmission.StatusSeeding, PercentDone: 1},
+		{ID: 3, Status: transmission.StatusStopped},
+	}}
+	p := newTorrentPoller(client)
+	start := time.Unix(1000, 0)
+	if err := p.poll(start); err != nil {
+		t.Fatal(err)
+	}
+
+	sub := p.subscribe()
+	update := <-sub.C
+	if len(update.torrents) != 3 || update.torrents[0].ID != 1 || len(update.events) != 0 {
+		t.Fatalf("Wrong first update: %+v", update)
+	}
+
+	// the full list is not fetched until fullPollInterval passes
+	client.torrents = nil
+	client.active = transmission.Torrents{{ID: 2, Status: transmission.StatusSeeding, PercentDone: 1}}
+	client.removed = []int{3}
+	if err := p.poll(start.Add(time.Second * interval)); err != nil {
+		t.Fatal(err)
+	}
+	client.active, client.removed = nil, nil
+	if err := p.poll(start.Add(2 * time.Second * interval)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscriber missed the second update, its events are kept in the last one
+	update = <-sub.C
+	if len(update.torrents) != 2 || update.torrents[1].Status != transmission.StatusSeeding {
+		t.Fatalf("Wrong torrents: %+v", update.torrents)
+	}
+	kinds := make(map[string]bool)
+	for _, e := range update.events {
+		kinds[e.kind] = true
+	}
+	for _, kind := range []string{"status", "progress", "finished", "removed"} {
+		if !kinds[kind] {
+			t.Errorf("No %s event in %v", kind, eventKinds(update.events))
+		}
+	}
+
+	p.unsubscribe(sub)
+	if err := p.poll(start.Add(fullPollInterval)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sub.C:
+		t.Fatal("Unsubscribed subscription is updated")
+	default:
+	}
+}
+
+func TestTorrentPollerMissed(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	client := &fakeTorrentClient{hashes: map[int]string{1: "aaa"}}
+	if err := newTorrentStates(client, s).save(transmission.Torrents{{ID: 1, Status: transmission.StatusDownloading, PercentDone: 0.5}}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.torrents = transmission.Torrents{{ID: 1, Status: transmission.StatusSeeding, PercentDone: 1}}
+	p := newTorrentPoller(client)
+	p.states = newTorrentStates(client, s)
+	sub := p.subscribe()
+	if err := p.poll(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	update := <-sub.C
+	if len(update.events) != 1 || update.events[0].kind != "finished" || !update.events[0].offline {
+		t.Fatalf("Wrong events: %v", eventKinds(update.events))
+	}
+}
+
+func TestTorrentPollerError(t *testing.T) {
+	client := &fakeTorrentClient{torrents: transmission.Torrents{{ID: 1}}}
+	p := newTorrentPoller(client)
+	start := time.Unix(1000, 0)
+	if err := p.poll(start); err != nil {
+		t.Fatal(err)
+	}
+	sub := p.subscribe()
+	<-sub.C
+
+	client.err = fmt.Errorf("connection refused")
+	if err := p.poll(start.Add(fullPollInterval)); err == nil {
+		t.Fatal("Poll error is not returned")
+	}
+	update := <-sub.C
+	if update.err != client.err || len(update.torrents) != 1 {
+		t.Fatalf("Wrong update of the failed poll: %+v", update)
+	}
+}
@@ -28,15 +28,17 @@ func list(bot telegramClient, client torrentClient, ud messageWrapper, s setting
 			checking(bot, client, ud, s)
 		case "er":
 			errors(bot, client, ud, s)
+		case "mine":
+			mine(bot, client, ud, s)
 		}
 	} else {
-		sendFilteredTorrets(bot, client, ud, func(t *transmission.Torrent) bool { return true })
+		sendFilteredTorrets(bot, client, ud, s, func(t *transmission.Torrent) bool { return true })
 	}
 }
 
 // downs will send the names of torrents with status 'Downloading' or in queue to
 func downs(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	sendFilteredTorrets(bot, client, ud, func(t *transmission.Torrent) bool {
+	sendFilteredTorrets(bot, client, ud, s, func(t *transmission.Torrent) bool {
 		return t.Status == transmission.StatusDownloading ||
 			t.Status == transmission.StatusDownloadPending
 	})
@@ -44,7 +46,7 @@ func downs(bot telegramClient, client torrentClient, ud messageWrapper, s settin
 
 // seeding will send the names of the torrents with the status 'Seeding' or in the queue to
 func seeding(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	sendFilteredTorrets(bot, client, ud, func(t *transmission.Torrent) bool {
+	sendFilteredTorrets(bot, client, ud, s, func(t *transmission.Torrent) bool {
 		return t.Status == transmission.StatusSeeding ||
 			t.Status == transmission.StatusSeedPending
 	})
@@ -52,14 +54,14 @@ func seeding(bot telegramClient, client torrentClient, ud messageWrapper, s sett
 
 // paused will send the names of the torrents with status 'Paused'
 func paused(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	sendFilteredTorrets(bot, client, ud, func(t *transmission.Torrent) bool {
+	sendFilteredTorrets(bot, client, ud, s, func(t *transmission.Torrent) bool {
 		return t.Status == transmission.StatusStopped
 	})
 }
 
 // checking will send the names of torrents with the status 'verifying' or in the queue to
 func checking(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	sendFilteredTorrets(bot, client, ud, func(t *transmission.Torrent) bool {
+	sendFilteredTorrets(bot, client, ud, s, func(t *transmission.Torrent) bool {
 		return t.Status == transmission.StatusChecking ||
 			t.Status == transmission.StatusCheckPending
 	})
@@ -67,16 +69,38 @@ func checking(bot telegramClient, client torrentClient, ud messageWrapper, s set
 
 // errors will send torrents with errors
 func errors(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
-	sendFilteredTorrets(bot, client, ud, func(t *transmission.Torrent) bool {
+	sendFilteredTorrets(bot, client, ud, s, func(t *transmission.Torrent) bool {
 		return t.Error != 0
 	})
 }
 
+// mine will send the torrents added by the user
+func mine(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	owners, err := torrentOwners(client, s)
+	if err != nil {
+		send(bot, "Torrents obtain error: "+escape(err.Error()), ud.Chat.ID, ud.Role())
+		return
+	}
+	torrents, err := client.GetTorrents()
+	if err != nil {
+		send(bot, "Torrents obtain error: "+escape(err.Error()), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	filteredTorrents := transmission.Torrents{}
+	for _, torrent := range torrents {
+		if owner, ok := owners[torrent.ID]; ok && owner.UserID == ud.From.ID {
+			filteredTorrents = append(filteredTorrents, torrent)
+		}
+	}
+	sendTorrents(bot, ud, filteredTorrents, owners)
+}
+
 // search takes a query and returns torrents with match
 func search(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
 	// make sure that we got a query
 	if len(ud.Tokens()) == 0 {
-		send(bot, "*search*: needs an argument", ud.Chat.ID, true)
+		send(bot, "<b>search</b>: needs an argument", ud.Chat.ID, ud.Role())
 		return
 	}
 
@@ -84,11 +108,11 @@ func search(bot telegramClient, client torrentClient, ud messageWrapper, s setti
 	// "(?i)" for case insensitivity
 	regx, err := regexp.Compile("(?i)" + query)
 	if err != nil {
-		send(bot, fmt.Sprintf("*search*: `%s`", err.Error()), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>search</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
 		return
 	}
 
-	sendFilteredTorrets(bot, client, ud, func(t *transmission.Torrent) bool {
+	sendFilteredTorrets(bot, client, ud, s, func(t *transmission.Torrent) bool {
 		return regx.MatchString(t.Name)
 	})
 }
@@ -97,10 +121,15 @@ func search(bot telegramClient, client torrentClient, ud messageWrapper, s setti
 func count(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
 	torrents, err := client.GetTorrents()
 	if err != nil {
-		send(bot, fmt.Sprintf("*count*: `%s`", err.Error()), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>count</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
 		return
 	}
 
+	send(bot, countByStatus(torrents), ud.Chat.ID, ud.Role())
+}
+
+// countByStatus formats torrents counts per status
+func countByStatus(torrents transmission.Torrents) string {
 	var downloading, seeding, stopped, checking, downloadingQ, seedingQ, checkingQ int
 
 	for i := range torrents {
@@ -122,37 +151,34 @@ func count(bot telegramClient, client torrentClient, ud messageWrapper, s settin
 		}
 	}
 
-	msg := fmt.Sprintf("*Downloading*: %d\n*Seeding*: %d\n*Paused*: %d\n*Verifying*: %d\n\n- Waiting to -\n*Download*: %d\n*Seed*: %d\n*Verify*: %d\n\n*Total*: %d",
+	return fmt.Sprintf("<b>Downloading</b>: %d\n<b>Seeding</b>: %d\n<b>Paused</b>: %d\n<b>Verifying</b>: %d\n\n- Waiting to -\n<b>Download</b>: %d\n<b>Seed</b>: %d\n<b>Verify</b>: %d\n\n<b>Total</b>: %d",
 		downloading, seeding, stopped, checking, downloadingQ, seedingQ, checkingQ, len(torrents))
-
-	send(bot, msg, ud.Chat.ID, true)
-
 }
 
 // stats echo back transmission stats
 func stats(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
 	stats, err := client.GetStats()
 	if err != nil {
-		send(bot, fmt.Sprintf("*stats*: `%s`", err.Error()), ud.Chat.ID, true)
+		send(bot, fmt.Sprintf("<b>stats</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
 		return
 	}
 
 	msg := fmt.Sprintf(
 		`
-		Total: *%d*
-		Active: *%d*
-		Paused: *%d*
-
-		_Current Stats_
-		Downloaded: *%s*
-		Uploaded: *%s*
-		Running time: *%s*
-
-		_Accumulative Stats_
-		Sessions: *%d*
-		Downloaded: *%s*
-		Uploaded: *%s*
-		Total Running time: *%s*
+		Total: <b>%d</b>
+		Active: <b>%d</b>
+		Paused: <b>%d</b>
+
+		<i>Current Stats</i>
+		Downloaded: <b>%s</b>
+		Uploaded: <b>%s</b>
+		Running time: <b>%s</b>
+
+		<i>Accumulative Stats</i>
+		Sessions: <b>%d</b>
+		Downloaded: <b>%s</b>
+		Uploaded: <b>%s</b>
+		Total Running time: <b>%s</b>
 		`,
 
 		stats.TorrentCount,
@@ -167,5 +193,5 @@ func stats(bot telegramClient, client torrentClient, ud messageWrapper, s settin
 		stats.CumulativeActiveTime(),
 	)
 
-	send(bot, msg, ud.Chat.ID, true)
+	send(bot, msg, ud.Chat.ID, ud.Role())
 }
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+// quotaMetadataTimeout is how long a magnet link is waited for metadata to check its size
+const quotaMetadataTimeout = 2 * time.Minute
+
+// quotaUsage is what a user's torrents take of the quota
+type quotaUsage struct {
+	torrents int
+	active   int
+	total    uint64
+	// ids are the IDs of all the torrents, not only the user's ones
+	ids map[int]bool
+}
+
+func getQuotaUsage(client torrentClient, s settings.Settings, userID int) (quotaUsage, error) {
+	usage := quotaUsage{ids: make(map[int]bool)}
+	owners, err := torrentOwners(client, s)
+	if err != nil {
+		return usage, err
+	}
+	torrents, err := client.GetTorrents()
+	if err != nil {
+		return usage, err
+	}
+	for _, t := range torrents {
+		usage.ids[t.ID] = true
+		if owner, ok := owners[t.ID]; !ok || owner.UserID != userID {
+			continue
+		}
+		usage.torrents++
+		usage.total += t.SizeWhenDone
+		if t.Status != transmission.StatusStopped {
+			usage.active++
+		}
+	}
+	return usage, nil
+}
+
+// waitForSize returns the size of the torrent, magnet links are started to get the metadata
+// and stopped again once it's there
+func waitForSize(client torrentClient, id int) (uint64, error) {
+	t, err := client.GetTorrent(id)
+	if err != nil {
+		return 0, err
+	}
+	if t.SizeWhenDone > 0 {
+		return t.SizeWhenDone, nil
+	}
+
+	if _, err := client.StartTorrent(id); err != nil {
+		return 0, err
+	}
+	defer client.StopTorrent(id)
+	deadline := time.Now().Add(quotaMetadataTimeout)
+	for {
+		t, err := client.GetTorrent(id)
+		if err != nil {
+			return 0, err
+		}
+		if t.SizeWhenDone > 0 {
+			return t.SizeWhenDone, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("no metadata in %s to check the size", quotaMetadataTimeout)
+		}
+		time.Sleep(time.Second * interval)
+	}
+}
+
+// checkQuotaSize returns an error if the torrent of the size doesn't fit in the quota
+func checkQuotaSize(quota settings.Quota, usage quotaUsage, name string, size uint64) error {
+	if quota.MaxSingle > 0 && size > quota.MaxSingle {
+		return fmt.Errorf("quota: %s is %s, the limit is %s", name, humanize.Bytes(size), humanize.Bytes(quota.MaxSingle))
+	}
+	if quota.MaxTotal > 0 && usage.total+size > quota.MaxTotal {
+		return fmt.Errorf("quota: %s is %s, you have %s of %s", name, humanize.Bytes(size), humanize.Bytes(usage.total), humanize.Bytes(quota.MaxTotal))
+	}
+	return nil
+}
+
+// addWithQuota adds the torrent if it fits in the quota of the user, torrents are added paused
+// to check their size and removed if they are too big. The user owns the torrent as soon as it's added,
+// so it counts in the quota of the following adds. Magnet links are checked in the background
+// once their metadata is there, report tells the user the outcome
+func addWithQuota(client torrentClient, s settings.Settings, userID int, username string, quota settings.Quota, a addArguments,
+	report func(text string)) (transmission.TorrentAdded, error) {
+	usage, err := getQuotaUsage(client, s, userID)
+	if err != nil {
+		return transmission.TorrentAdded{}, err
+	}
+	if quota.MaxActive > 0 && usage.active >= quota.MaxActive {
+		return transmission.TorrentAdded{}, fmt.Errorf("quota: you have %d active torrents of %d", usage.active, quota.MaxActive)
+	}
+	if quota.MaxTotal == 0 && quota.MaxSingle == 0 {
+		return client.AddTorrent(a)
+	}
+
+	a.paused = true
+	torrent, err := client.AddTorrent(a)
+	if err != nil || torrent.Name == "" || usage.ids[torrent.ID] {
+		// the torrent is already there, it's neither checked nor removed
+		return torrent, err
+	}
+	recordOwner(s, torrent, userID, username)
+
+	t, err := client.GetTorrent(torrent.ID)
+	if err == nil && t.SizeWhenDone == 0 {
+		go checkMetadataQuota(client, s, userID, quota, torrent, report)
+		return torrent, nil
+	}
+	if err == nil {
+		err = checkQuotaSize(quota, usage, torrent.Name, t.SizeWhenDone)
+	}
+	if err != nil {
+		client.DeleteTorrent(torrent.ID, true)
+		return transmission.TorrentAdded{}, err
+	}
+
+	if _, err := client.StartTorrent(torrent.ID); err != nil {
+		return torrent, err
+	}
+	return torrent, nil
+}
+
+// checkMetadataQuota waits for the metadata of the paused torrent, then starts it if it fits
+// in the quota of the user or removes it
+func checkMetadataQuota(client torrentClient, s settings.Settings, userID int, quota settings.Quota, torrent transmission.TorrentAdded,
+	report func(text string)) {
+	size, err := waitForSize(client, torrent.ID)
+	if err == nil {
+		var usage quotaUsage
+		if usage, err = getQuotaUsage(client, s, userID); err == nil {
+			if owner, _ := s.GetTorrentOwner(torrent.HashString); owner != nil && owner.UserID == userID {
+				// the torrent itself is counted already
+				usage.total -= size
+			}
+			err = checkQuotaSize(quota, usage, torrent.Name, size)
+		}
+	}
+	if err == nil {
+		_, err = client.StartTorrent(torrent.ID)
+	}
+	if err != nil {
+		client.DeleteTorrent(torrent.ID, true)
+		report(fmt.Sprintf("<b>%d</b> <code>%s</code> is removed: <code>%s</code>", torrent.ID, escape(torrent.Name), escape(err.Error())))
+		return
+	}
+	report(fmt.Sprintf("<b>%d</b> <code>%s</code> fits in the quota and is started", torrent.ID, escape(torrent.Name)))
+}
+
+func formatLimit(limit uint64, format func(uint64) string) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return format(limit)
+}
+
+func formatCount(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func describeQuota(quota settings.Quota, usage quotaUsage) string {
+	return fmt.Sprintf("Torrents: <b>%d</b>\nActive: <b>%d</b> of <b>%s</b>\nTotal size: <b>%s</b> of <b>%s</b>\nSingle torrent: up to <b>%s</b>",
+		usage.torrents, usage.active, formatLimit(uint64(quota.MaxActive), formatCount),
+		humanize.Bytes(usage.total), formatLimit(quota.MaxTotal, humanize.Bytes), formatLimit(quota.MaxSingle, humanize.Bytes))
+}
+
+// quota shows the quota of the user: quota, quota <user> or quota set <user> active|total|single <value> for admins
+func quota(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	tokens := ud.Tokens()
+	if len(tokens) > 0 && ud.Role() < roleAdmin {
+		send(bot, "<b>quota</b>: only admins can see and change quotas of others", ud.Chat.ID, ud.Role())
+		return
+	}
+	if len(tokens) > 0 && strings.ToLower(tokens[0]) == "set" {
+		setQuota(bot, ud, s, tokens[1:])
+		return
+	}
+
+	userID := ud.From.ID
+	if len(tokens) > 0 {
+		id, err := resolveUserID(tokens[0], s)
+		if err != nil || id == 0 {
+			send(bot, fmt.Sprintf("<b>quota</b>: unknown user <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+			return
+		}
+		userID = id
+	}
+
+	q, err := s.GetUserQuota(strconv.Itoa(userID))
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>quota</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	usage, err := getQuotaUsage(client, s, userID)
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>quota</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, "<b>quota</b>\n"+describeQuota(q, usage), ud.Chat.ID, ud.Role())
+}
+
+// setQuota changes a limit of the user's quota, 0 or off removes the limit
+func setQuota(bot telegramClient, ud messageWrapper, s settings.Settings, tokens []string) {
+	if len(tokens) < 3 {
+		send(bot, "<b>quota set</b>: takes <code>&lt;id|@name&gt; active|total|single &lt;value&gt;</code>, e.g. <code>quota set @user total 100GB</code>", ud.Chat.ID, ud.Role())
+		return
+	}
+	userID, err := resolveUserID(tokens[0], s)
+	if err != nil || userID == 0 {
+		send(bot, fmt.Sprintf("<b>quota set</b>: unknown user <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+		return
+	}
+	key := strconv.Itoa(userID)
+	q, err := s.GetUserQuota(key)
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>quota set</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	value := tokens[2]
+	if strings.ToLower(value) == "off" {
+		value = "0"
+	}
+	switch strings.ToLower(tokens[1]) {
+	case "active":
+		q.MaxActive, err = strconv.Atoi(value)
+	case "total":
+		q.MaxTotal, err = humanize.ParseBytes(value)
+	case "single":
+		q.MaxSingle, err = humanize.ParseBytes(value)
+	default:
+		send(bot, fmt.Sprintf("<b>quota set</b>: unknown limit <code>%s</code>, use active, total or single", escape(tokens[1])), ud.Chat.ID, ud.Role())
+		return
+	}
+	if err != nil || q.MaxActive < 0 {
+		send(bot, fmt.Sprintf("<b>quota set</b>: wrong value <code>%s</code>", escape(tokens[2])), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	if err := s.SetUserQuota(key, q); err != nil {
+		send(bot, fmt.Sprintf("<b>quota set</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, fmt.Sprintf("<b>quota set</b>: <code>%s</code>\nActive: <b>%s</b>\nTotal size: <b>%s</b>\nSingle torrent: <b>%s</b>", escape(key),
+		formatLimit(uint64(q.MaxActive), formatCount), formatLimit(q.MaxTotal, humanize.Bytes), formatLimit(q.MaxSingle, humanize.Bytes)), ud.Chat.ID, ud.Role())
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+// quotaClient adds torrents of the size, magnet links get the size once they are started
+type quotaClient struct {
+	fakeTorrentClient
+	size    uint64
+	magnet  bool
+	deleted []int
+	started []int
+	stopped []int
+}
+
+func (c *quotaClient) AddTorrent(a addArguments) (transmission.TorrentAdded, error) {
+	id := len(c.torrents) + 1
+	t := &transmission.Torrent{ID: id, Name: a.url, SizeWhenDone: c.size}
+	if c.magnet {
+		t.SizeWhenDone = 0
+	}
+	c.torrents = append(c.torrents, t)
+	c.hashes[id] = a.url
+	return transmission.TorrentAdded{ID: id, Name: a.url, HashString: a.url}, nil
+}
+
+func (c *quotaClient) GetTorrent(id int) (*transmission.Torrent, error) {
+	return findTorrent(c.torrents, id), nil
+}
+
+func (c *quotaClient) StartTorrent(id int) (string, error) {
+	c.started = append(c.started, id)
+	if t := findTorrent(c.torrents, id); t != nil {
+		t.SizeWhenDone = c.size
+	}
+	return "", nil
+}
+
+func (c *quotaClient) StopTorrent(id int) (string, error) {
+	c.stopped = append(c.stopped, id)
+	return "", nil
+}
+
+func (c *quotaClient) DeleteTorrent(id int, wd bool) (string, error) {
+	c.deleted = append(c.deleted, id)
+	return "", nil
+}
+
+func TestAddWithQuota(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	client := &quotaClient{fakeTorrentClient: fakeTorrentClient{hashes: map[int]string{}}}
+	q := settings.Quota{MaxActive: 2, MaxTotal: 300, MaxSingle: 200}
+
+	client.size = 250
+	if _, err := addWithQuota(client, s, 1, "", q, addArguments{url: "big"}, nil); err == nil || !strings.Contains(err.Error(), "the limit is") {
+		t.Fatalf("Too big torrent is added: %v", err)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != 1 {
+		t.Fatal("Too big torrent is not removed")
+	}
+
+	client.torrents = nil
+	client.size = 200
+	torrent, err := addWithQuota(client, s, 1, "", q, addArguments{url: "first"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.started) != 1 || client.started[0] != torrent.ID {
+		t.Fatal("Torrent is not started")
+	}
+	if owner, _ := s.GetTorrentOwner("first"); owner == nil || owner.UserID != 1 {
+		t.Fatal("Owner is not recorded")
+	}
+
+	if _, err := addWithQuota(client, s, 1, "", q, addArguments{url: "second"}, nil); err == nil || !strings.Contains(err.Error(), "you have") {
+		t.Fatalf("Torrent over the total size is added: %v", err)
+	}
+	if _, err := addWithQuota(client, s, 2, "", q, addArguments{url: "other"}, nil); err != nil {
+		t.Fatalf("Torrent of another user is not added: %v", err)
+	}
+}
+
+func TestAddWithQuotaMagnet(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	client := &quotaClient{fakeTorrentClient: fakeTorrentClient{hashes: map[int]string{}}, magnet: true}
+	q := settings.Quota{MaxSingle: 200}
+	reports := make(chan string, 1)
+	report := func(text string) { reports <- text }
+
+	client.size = 250
+	torrent, err := addWithQuota(client, s, 1, "", q, addArguments{url: "big"}, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the owner is known before the size is
+	if owner, _ := s.GetTorrentOwner("big"); owner == nil || owner.UserID != 1 {
+		t.Fatal("Owner is not recorded at once")
+	}
+	if text := <-reports; !strings.Contains(text, "the limit is") {
+		t.Fatalf("Wrong report: %s", text)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != torrent.ID {
+		t.Fatal("Too big magnet is not removed")
+	}
+
+	client.size = 100
+	torrent, err = addWithQuota(client, s, 1, "", q, addArguments{url: "small"}, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := <-reports; !strings.Contains(text, "is started") {
+		t.Fatalf("Wrong report: %s", text)
+	}
+	// started for the metadata, stopped and started again
+	if len(client.started) != 3 || client.started[2] != torrent.ID || len(client.stopped) != 2 {
+		t.Fatalf("Wrong starts %v and stops %v", client.started, client.stopped)
+	}
+}
+
+func TestAddWithQuotaMaxActive(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	client := &quotaClient{fakeTorrentClient: fakeTorrentClient{hashes: map[int]string{1: "one", 2: "two"}}}
+	client.torrents = transmission.Torrents{
+		{ID: 1, Status: transmission.StatusDownloading},
+		{ID: 2, Status: transmission.StatusStopped},
+	}
+	s.SetTorrentOwner("one", settings.TorrentOwner{UserID: 1})
+	s.SetTorrentOwner("two", settings.TorrentOwner{UserID: 1})
+	q := settings.Quota{MaxActive: 1}
+
+	if _, err := addWithQuota(client, s, 1, "", q, addArguments{url: "third"}, nil); err == nil || !strings.Contains(err.Error(), "active torrents") {
+		t.Fatalf("Torrent over the active limit is added: %v", err)
+	}
+	client.torrents[0].Status = transmission.StatusStopped
+	if _, err := addWithQuota(client, s, 1, "", q, addArguments{url: "third"}, nil); err != nil {
+		t.Fatalf("Torrent under the active limit is not added: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "strings"
+
+// role defines which commands a user may run, each role includes all permissions of the previous one
+type role int
+
+const (
+	roleNone role = iota
+	// roleViewer can list, search, view info and see speeds
+	roleViewer
+	// roleOperator can also add, start, stop and verify torrents
+	roleOperator
+	// roleAdmin can also delete torrents and their data
+	roleAdmin
+)
+
+var roleNames = map[role]string{
+	roleViewer:   "viewer",
+	roleOperator: "operator",
+	roleAdmin:    "admin",
+}
+
+func (r role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "none"
+}
+
+func parseRole(name string) (role, bool) {
+	for r, n := range roleNames {
+		if n == strings.ToLower(name) {
+			return r, true
+		}
+	}
+	return roleNone, false
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+const (
+	rssTimeout = 30 * time.Second
+	// maxFeedSize limits the size of the downloaded feeds
+	maxFeedSize = 10 << 20
+)
+
+// rssInterval is how often the feeds are checked
+var rssInterval = 15 * time.Minute
+
+var (
+	rssClient    = &http.Client{Timeout: rssTimeout}
+	episodeRegex = regexp.MustCompile(`(?i)^(.*?)[\s._-]*\bS(\d{1,2})E(\d{1,3})\b`)
+	nonWordRegex = regexp.MustCompile(`[^\pL\pN]+`)
+)
+
+// feedItem is an entry of an RSS or Atom feed
+type feedItem struct {
+	guid  string
+	title string
+	link  string
+	// size is 0 if the feed doesn't tell it
+	size uint64
+}
+
+type rssItem struct {
+	Title     string `xml:"title"`
+	GUID      string `xml:"guid"`
+	Link      string `xml:"link"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length string `xml:"length,attr"`
+	} `xml:"enclosure"`
+	// contentLength is used by torrent feeds, size attributes by torznab
+	ContentLength string `xml:"contentLength"`
+	Attributes    []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"attr"`
+}
+
+type atomEntry struct {
+	Title string `xml:"title"`
+	ID    string `xml:"id"`
+	Links []struct {
+		Href   string `xml:"href,attr"`
+		Rel    string `xml:"rel,attr"`
+		Length string `xml:"length,attr"`
+	} `xml:"link"`
+}
+
+// feedDocument is either an RSS document with items or an Atom feed with entries
+type feedDocument struct {
+	Items   []rssItem   `xml:"channel>item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func parseSize(values ...string) uint64 {
+	for _, v := range values {
+		if size, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64); err == nil && size > 0 {
+			return size
+		}
+	}
+	return 0
+}
+
+func parseFeed(data []byte) ([]feedItem, error) {
+	var doc feedDocument
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// feeds declare all kinds of encodings, the names are ASCII anyway
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var items []feedItem
+	for _, i := range doc.Items {
+		item := feedItem{guid: i.GUID, title: strings.TrimSpace(i.Title), link: strings.TrimSpace(i.Link)}
+		if i.Enclosure.URL != "" {
+			item.link = i.Enclosure.URL
+		}
+		size := []string{i.Enclosure.Length, i.ContentLength}
+		for _, attr := range i.Attributes {
+			if attr.Name == "size" {
+				size = append(size, attr.Value)
+			}
+		}
+		item.size = parseSize(size...)
+		items = append(items, item)
+	}
+	for _, e := range doc.Entries {
+		item := feedItem{guid: e.ID, title: strings.TrimSpace(e.Title)}
+		for _, link := range e.Links {
+			if link.Rel == "enclosure" || item.link == "" {
+				item.link = link.Href
+				item.size = parseSize(link.Length)
+			}
+		}
+		items = append(items, item)
+	}
+
+	for i := range items {
+		if items[i].guid == "" {
+			items[i].guid = items[i].link
+		}
+	}
+	return items, nil
+}
+
+func fetchFeed(url string) ([]feedItem, error) {
+	resp, err := rssClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseFeed(data)
+}
+
+// episodeKey returns the show and episode number of the title like "show s01e02", it's empty for other titles
+func episodeKey(title string) string {
+	m := episodeRegex.FindStringSubmatch(title)
+	if m == nil {
+		return ""
+	}
+	show := strings.TrimSpace(nonWordRegex.ReplaceAllString(strings.ToLower(m[1]), " "))
+	season, _ := strconv.Atoi(m[2])
+	episode, _ := strconv.Atoi(m[3])
+	return fmt.Sprintf("%s s%02de%02d", show, season, episode)
+}
+
+// matchFeedRule returns the first rule matching the item or nil, items of unknown
+// size don't match rules with size bounds
+func matchFeedRule(rules []settings.FeedRule, item feedItem) *settings.FeedRule {
+	for i, rule := range rules {
+		regex, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil || !regex.MatchString(item.title) {
+			continue
+		}
+		if (rule.MinSize > 0 || rule.MaxSize > 0) && item.size == 0 {
+			continue
+		}
+		if rule.MinSize > 0 && item.size < rule.MinSize || rule.MaxSize > 0 && item.size > rule.MaxSize {
+			continue
+		}
+		return &rules[i]
+	}
+	return nil
+}
+
+// checkFeed adds the new items of the feed matching its rules and reports them to the user who added the feed
+func checkFeed(bot telegramClient, client torrentClient, s settings.Settings, feed settings.Feed) error {
+	items, err := fetchFeed(feed.URL)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if item.guid == "" || item.link == "" {
+			continue
+		}
+		guid := "guid:" + item.guid
+		marked, err := s.MarkFeedItem(feed.ID, guid)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			continue
+		}
+
+		rule := matchFeedRule(feed.Rules, item)
+		if rule == nil {
+			continue
+		}
+		var episode string
+		if key := episodeKey(item.title); rule.Episodes && key != "" {
+			episode = "episode:" + key
+			marked, err := s.MarkFeedItem(feed.ID, episode)
+			if err != nil {
+				return err
+			}
+			if !marked {
+				continue
+			}
+		}
+
+		torrent, err := addForUser(client, s, feed.UserID, feed.Username, addArguments{url: item.link, dir: rule.DownloadDir}, func(text string) {
+			send(bot, fmt.Sprintf("<b>rss</b>: feed <b>%d</b>: %s", feed.ID, text), int64(feed.UserID), roleNone)
+		})
+		if _, ok := err.(*duplicateError); err == nil || ok {
+			// a later failure of the item is told again
+			if err := s.UnmarkFeedItem(feed.ID, "failed:"+item.guid); err != nil {
+				return err
+			}
+		} else {
+			// the item is tried again on the next check, the owner is told about the first failure only
+			for _, key := range []string{guid, episode} {
+				if key == "" {
+					continue
+				}
+				if err := s.UnmarkFeedItem(feed.ID, key); err != nil {
+					return err
+				}
+			}
+			if first, _ := s.MarkFeedItem(feed.ID, "failed:"+item.guid); !first {
+				continue
+			}
+		}
+		if err != nil {
+			send(bot, fmt.Sprintf("<b>rss</b>: feed <b>%d</b> <code>%s</code>: %s", feed.ID, escape(item.title), describeAddError(client, err)), int64(feed.UserID), roleNone)
+			continue
+		}
+		send(bot, fmt.Sprintf("<b>rss</b>: <b>%d</b> <code>%s</code> is added from feed <b>%d</b>", torrent.ID, escape(torrent.Name), feed.ID), int64(feed.UserID), roleNone)
+	}
+	return nil
+}
+
+func checkFeeds(bot telegramClient, client torrentClient, s settings.Settings) {
+	feeds, err := s.GetFeeds()
+	if err != nil {
+		log.Println("GetFeeds failed:", err.Error())
+		return
+	}
+	for _, feed := range feeds {
+		if err := checkFeed(bot, client, s, feed); err != nil {
+			log.Printf("[ERROR] Feed %d: %s", feed.ID, err)
+		}
+	}
+}
+
+// watchFeeds checks the feeds every rssInterval
+func watchFeeds(bot telegramClient, client torrentClient, s settings.Settings) {
+	for {
+		time.Sleep(rssInterval)
+		checkFeeds(bot, client, s)
+	}
+}
+
+func formatFeedRule(rule settings.FeedRule) string {
+	text := fmt.Sprintf("<code>%s</code>", escape(rule.Pattern))
+	if rule.MinSize > 0 {
+		text += " min: <b>" + humanize.Bytes(rule.MinSize) + "</b>"
+	}
+	if rule.MaxSize > 0 {
+		text += " max: <b>" + humanize.Bytes(rule.MaxSize) + "</b>"
+	}
+	if rule.Episodes {
+		text += " <i>episodes</i>"
+	}
+	if rule.DownloadDir != "" {
+		text += " dir: <code>" + escape(rule.DownloadDir) + "</code>"
+	}
+	return text
+}
+
+// parseFeedRule parses a rule like /S\d\dE\d\d/ min:100MB max:2GB episodes dir:/downloads/tv
+func parseFeedRule(tokens []string) (settings.FeedRule, error) {
+	var rule settings.FeedRule
+	if len(tokens) == 0 {
+		return rule, fmt.Errorf("needs a pattern")
+	}
+	rule.Pattern = tokens[0]
+	if len(rule.Pattern) > 1 && strings.HasPrefix(rule.Pattern, "/") && strings.HasSuffix(rule.Pattern, "/") {
+		rule.Pattern = rule.Pattern[1 : len(rule.Pattern)-1]
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return rule, err
+	}
+
+	for _, token := range tokens[1:] {
+		var err error
+		switch {
+		case strings.ToLower(token) == "episodes":
+			rule.Episodes = true
+		case strings.HasPrefix(token, "min:"):
+			rule.MinSize, err = humanize.ParseBytes(token[4:])
+		case strings.HasPrefix(token, "max:"):
+			rule.MaxSize, err = humanize.ParseBytes(token[4:])
+		case strings.HasPrefix(token, "dir:"):
+			rule.DownloadDir = token[4:]
+		default:
+			err = fmt.Errorf("unknown option %q", token)
+		}
+		if err != nil {
+			return rule, err
+		}
+	}
+	return rule, nil
+}
+
+// rss manages the feeds: rss add <url>, rss list, rss rm <id>, rss rule <id> add <pattern> [options],
+// rss rule <id> rm <n> and rss check
+func rss(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	tokens := ud.Tokens()
+	command := "list"
+	if len(tokens) > 0 {
+		command = strings.ToLower(tokens[0])
+		tokens = tokens[1:]
+	}
+
+	switch command {
+	case "list", "ls":
+		listFeeds(bot, ud, s)
+	case "add":
+		addFeed(bot, ud, s, tokens)
+	case "check":
+		checkFeeds(bot, client, s)
+		send(bot, "<b>rss</b>: feeds are checked", ud.Chat.ID, ud.Role())
+	case "rm", "remove", "del", "rule":
+		if len(tokens) == 0 {
+			send(bot, fmt.Sprintf("<b>rss %s</b>: needs a feed ID", command), ud.Chat.ID, ud.Role())
+			return
+		}
+		feed, ok := findFeed(bot, ud, s, tokens[0])
+		if !ok {
+			return
+		}
+		if command == "rule" {
+			feedRule(bot, ud, s, feed, tokens[1:])
+			return
+		}
+		if err := s.DeleteFeed(feed.ID); err != nil {
+			send(bot, fmt.Sprintf("<b>rss rm</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			return
+		}
+		send(bot, fmt.Sprintf("<b>rss rm</b>: feed <b>%d</b> is removed", feed.ID), ud.Chat.ID, ud.Role())
+	default:
+		send(bot, fmt.Sprintf("<b>rss</b>: Unknown argument <code>%s</code>, use add, list, rm, rule or check", escape(command)), ud.Chat.ID, ud.Role())
+	}
+}
+
+func listFeeds(bot telegramClient, ud messageWrapper, s settings.Settings) {
+	feeds, err := s.GetFeeds()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>rss</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	if len(feeds) == 0 {
+		send(bot, "<b>rss</b>: no feeds, add one with <code>rss add &lt;url&gt;</code>", ud.Chat.ID, ud.Role())
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	for _, feed := range feeds {
+		buf.WriteString(fmt.Sprintf("<b>%d</b> <code>%s</code> %s\n", feed.ID, escape(feed.URL), escape(ownerName(settings.TorrentOwner{UserID: feed.UserID, Username: feed.Username}))))
+		if len(feed.Rules) == 0 {
+			buf.WriteString("    <i>no rules, nothing is downloaded</i>\n")
+		}
+		for i, rule := range feed.Rules {
+			buf.WriteString(fmt.Sprintf("    %d. %s\n", i+1, formatFeedRule(rule)))
+		}
+	}
+	send(bot, buf.String(), ud.Chat.ID, ud.Role())
+}
+
+// addFeed stores the feed, its current items are marked as seen so only the new ones are downloaded
+func addFeed(bot telegramClient, ud messageWrapper, s settings.Settings, tokens []string) {
+	if len(tokens) == 0 {
+		send(bot, "<b>rss add</b>: needs a feed URL", ud.Chat.ID, ud.Role())
+		return
+	}
+	items, err := fetchFeed(tokens[0])
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>rss add</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	id, err := s.AddFeed(settings.Feed{URL: tokens[0], UserID: ud.From.ID, Username: ud.From.UserName, Added: time.Now()})
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>rss add</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	for _, item := range items {
+		if item.guid == "" {
+			continue
+		}
+		if _, err := s.MarkFeedItem(id, "guid:"+item.guid); err != nil {
+			log.Println("MarkFeedItem failed:", err.Error())
+		}
+	}
+	send(bot, fmt.Sprintf("<b>rss add</b>: feed <b>%d</b> with %d items is added, new items matching its rules will be downloaded, "+
+		"add one with <code>rss rule %d add /pattern/</code>", id, len(items), id), ud.Chat.ID, ud.Role())
+}
+
+// findFeed returns the feed with the ID, only admins can change feeds of others
+func findFeed(bot telegramClient, ud messageWrapper, s settings.Settings, id string) (settings.Feed, bool) {
+	feeds, err := s.GetFeeds()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>rss</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return settings.Feed{}, false
+	}
+	for _, feed := range feeds {
+		if strconv.Itoa(feed.ID) != id {
+			continue
+		}
+		if feed.UserID != ud.From.ID && ud.Role() < roleAdmin {
+			send(bot, fmt.Sprintf("<b>rss</b>: feed <b>%d</b> is not yours", feed.ID), ud.Chat.ID, ud.Role())
+			return feed, false
+		}
+		return feed, true
+	}
+	send(bot, fmt.Sprintf("<b>rss</b>: no feed <code>%s</code>", escape(id)), ud.Chat.ID, ud.Role())
+	return settings.Feed{}, false
+}
+
+func feedRule(bot telegramClient, ud messageWrapper, s settings.Settings, feed settings.Feed, tokens []string) {
+	if len(tokens) == 0 {
+		send(bot, "<b>rss rule</b>: takes <code>add &lt;pattern&gt; [min:SIZE] [max:SIZE] [episodes] [dir:PATH]</code> or <code>rm &lt;n&gt;</code>", ud.Chat.ID, ud.Role())
+		return
+	}
+
+	switch strings.ToLower(tokens[0]) {
+	case "add":
+		rule, err := parseFeedRule(tokens[1:])
+		if err != nil {
+			send(bot, fmt.Sprintf("<b>rss rule</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			return
+		}
+		feed.Rules = append(feed.Rules, rule)
+	case "rm", "remove", "del":
+		n := 0
+		if len(tokens) > 1 {
+			n, _ = strconv.Atoi(tokens[1])
+		}
+		if n < 1 || n > len(feed.Rules) {
+			send(bot, "<b>rss rule rm</b>: needs a rule number", ud.Chat.ID, ud.Role())
+			return
+		}
+		feed.Rules = append(feed.Rules[:n-1], feed.Rules[n:]...)
+	default:
+		send(bot, fmt.Sprintf("<b>rss rule</b>: Unknown argument <code>%s</code>, use add or rm", escape(tokens[0])), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	if err := s.UpdateFeed(feed); err != nil {
+		send(bot, fmt.Sprintf("<b>rss rule</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, fmt.Sprintf("<b>rss rule</b>: feed <b>%d</b> has %d rules", feed.ID, len(feed.Rules)), ud.Chat.ID, ud.Role())
+}
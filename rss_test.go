@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+const testRSS = `<?xml version="1.0" encoding="windows-1251"?>
+<rss version="2.0" xmlns:torznab="http://torznab.com/schemas/2015/feed">
+<channel>
+	<item>
+		<title>Show S01E01 720p</title>
+		<guid>1</guid>
+		<link>http://example.org/1</link>
+		<enclosure url="http://example.org/1.torrent" length="500000000" type="application/x-bittorrent"/>
+	</item>
+	<item>
+		<title>Show.S01E01.1080p</title>
+		<guid>2</guid>
+		<enclosure url="http://example.org/2.torrent" length="900000000" type="application/x-bittorrent"/>
+	</item>
+	<item>
+		<title>Show S01E02 720p</title>
+		<link>magnet:?xt=urn:btih:aaa</link>
+		<torznab:attr name="size" value="400000000"/>
+	</item>
+	<item>
+		<title>Other S01E01</title>
+		<guid>4</guid>
+		<link>http://example.org/4.torrent</link>
+	</item>
+</channel>
+</rss>`
+
+const testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<entry>
+		<title>Atom entry</title>
+		<id>urn:1</id>
+		<link href="http://example.org/page"/>
+		<link rel="enclosure" href="http://example.org/atom.torrent" length="1000"/>
+	</entry>
+</feed>`
+
+// addClient records the added torrents, errs are returned by the following adds in turn,
+// the adds getting nil succeed
+type addClient struct {
+	fakeTorrentClient
+	added []addArguments
+	errs  []error
+}
+
+func (c *addClient) AddTorrent(a addArguments) (transmission.TorrentAdded, error) {
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		if err != nil {
+			return transmission.TorrentAdded{}, err
+		}
+	}
+	c.added = append(c.added, a)
+	name := fmt.Sprintf("torrent %d", len(c.added))
+	return transmission.TorrentAdded{ID: len(c.added), Name: name, HashString: name}, nil
+}
+
+func TestParseFeed(t *testing.T) {
+	items, err := parseFeed([]byte(testRSS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 4 {
+		t.Fatalf("Wrong number of items: %+v", items)
+	}
+	expected := feedItem{guid: "1", title: "Show S01E01 720p", link: "http://example.org/1.torrent", size: 500000000}
+	if items[0] != expected {
+		t.Errorf("Wrong first item: %+v", items[0])
+	}
+	if items[2].guid != "magnet:?xt=urn:btih:aaa" || items[2].size != 400000000 {
+		t.Errorf("Wrong torznab item: %+v", items[2])
+	}
+
+	items, err = parseFeed([]byte(testAtom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = feedItem{guid: "urn:1", title: "Atom entry", link: "http://example.org/atom.torrent", size: 1000}
+	if len(items) != 1 || items[0] != expected {
+		t.Fatalf("Wrong Atom items: %+v", items)
+	}
+}
+
+func TestEpisodeKey(t *testing.T) {
+	cases := map[string]string{
+		"Show S01E01 720p":      "show s01e01",
+		"Show.S1E1.1080p":       "show s01e01",
+		"The_Show - s02e10 WEB": "the show s02e10",
+		"Movie 2020 1080p":      "",
+	}
+	for title, key := range cases {
+		if k := episodeKey(title); k != key {
+			t.Errorf("episodeKey(%q) = %q, expected %q", title, k, key)
+		}
+	}
+}
+
+func TestCheckFeed(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, strings.Replace(testRSS, "http://example.org", server.URL, -1))
+	}))
+	defer server.Close()
+
+	rule := settings.FeedRule{Pattern: `^show.*720p`, Episodes: true}
+	if _, err := s.AddFeed(settings.Feed{URL: server.URL, UserID: 1, Rules: []settings.FeedRule{rule}, Added: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	feeds, err := s.GetFeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first item fails and is added on the next check
+	client := &addClient{fakeTorrentClient: fakeTorrentClient{hashes: map[int]string{}}, errs: []error{fmt.Errorf("connection refused")}}
+	fake := &fakeTelegramClient{}
+	if err := checkFeed(fake, client, s, feeds[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.added) != 1 || client.added[0].url != "magnet:?xt=urn:btih:aaa" {
+		t.Fatalf("Wrong torrents are added: %v", client.added)
+	}
+	if err := checkFeed(fake, client, s, feeds[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.added) != 2 || client.added[1].url != server.URL+"/1.torrent" {
+		t.Fatalf("Failed item is not added again: %v", client.added)
+	}
+	if first, err := s.MarkFeedItem(feeds[0].ID, "failed:1"); err != nil || !first {
+		t.Fatalf("The failure of the added item is kept: %v", err)
+	}
+	owners, err := s.GetTorrentOwners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 2 {
+		t.Fatalf("Owners are not recorded: %+v", owners)
+	}
+
+	// the items are already seen
+	if err := checkFeed(fake, client, s, feeds[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.added) != 2 {
+		t.Fatalf("Seen items are added again: %v", client.added)
+	}
+}
+
+func TestMatchFeedRule(t *testing.T) {
+	rules := []settings.FeedRule{{Pattern: "720p", MinSize: 100, MaxSize: 1000}, {Pattern: "1080p"}}
+	cases := []struct {
+		item     feedItem
+		expected int
+	}{
+		{feedItem{title: "Show 720P", size: 500}, 0},
+		{feedItem{title: "Show 720p", size: 5000}, -1},
+		{feedItem{title: "Show 720p"}, -1},
+		{feedItem{title: "Show 1080p"}, 1},
+		{feedItem{title: "Show"}, -1},
+	}
+	for _, c := range cases {
+		rule := matchFeedRule(rules, c.item)
+		if c.expected < 0 && rule != nil || c.expected >= 0 && rule != &rules[c.expected] {
+			t.Errorf("Wrong rule for %+v: %+v", c.item, rule)
+		}
+	}
+}
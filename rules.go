@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pyed/transmission"
+)
+
+// torrentRule matches torrents by a field: name:text, tracker:host or dir:path,
+// the value can also be a /regular expression/
+type torrentRule struct {
+	field string
+	value string
+	regex *regexp.Regexp
+}
+
+var ruleFields = map[string]bool{"name": true, "tracker": true, "dir": true}
+
+func parseTorrentRule(rule string) (torrentRule, error) {
+	i := strings.Index(rule, ":")
+	if i < 0 {
+		return torrentRule{}, fmt.Errorf("rule %q has no field, use name:, tracker: or dir:", rule)
+	}
+	r := torrentRule{field: strings.ToLower(rule[:i]), value: rule[i+1:]}
+	if !ruleFields[r.field] {
+		return torrentRule{}, fmt.Errorf("unknown field %q, use name, tracker or dir", r.field)
+	}
+	if r.value == "" {
+		return torrentRule{}, fmt.Errorf("rule %q has no value", rule)
+	}
+
+	if len(r.value) > 1 && strings.HasPrefix(r.value, "/") && strings.HasSuffix(r.value, "/") {
+		regex, err := regexp.Compile("(?i)" + r.value[1:len(r.value)-1])
+		if err != nil {
+			return torrentRule{}, err
+		}
+		r.regex = regex
+	}
+	return r, nil
+}
+
+func (r torrentRule) String() string {
+	return r.field + ":" + r.value
+}
+
+func (r torrentRule) matchValue(value string) bool {
+	if r.regex != nil {
+		return r.regex.MatchString(value)
+	}
+	return strings.Contains(strings.ToLower(value), strings.ToLower(r.value))
+}
+
+// trackerHost returns the host of the announce URL
+func trackerHost(announce string) string {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return announce
+	}
+	return u.Hostname()
+}
+
+// inDir reports whether the path is the dir or inside it, /downloads doesn't contain /downloads2
+func inDir(p, dir string) bool {
+	p, dir = path.Clean(p), path.Clean(dir)
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+func (r torrentRule) match(t *transmission.Torrent) bool {
+	switch r.field {
+	case "name":
+		return r.matchValue(t.Name)
+	case "dir":
+		if r.regex != nil {
+			return r.regex.MatchString(t.DownloadDir)
+		}
+		return inDir(t.DownloadDir, r.value)
+	case "tracker":
+		for _, tracker := range t.Trackers {
+			host := trackerHost(tracker.Announce)
+			if r.regex != nil {
+				if r.regex.MatchString(host) {
+					return true
+				}
+				continue
+			}
+			value := strings.ToLower(r.value)
+			if host == value || strings.HasSuffix(host, "."+value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchAnyRule reports whether the torrent matches one of the rules, any torrent matches no rules
+func matchAnyRule(rules []string, t *transmission.Torrent) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	for _, rule := range rules {
+		r, err := parseTorrentRule(rule)
+		if err != nil {
+			continue
+		}
+		if r.match(t) {
+			return true
+		}
+	}
+	return false
+}
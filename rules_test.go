@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pyed/transmission"
+)
+
+func TestTorrentRules(t *testing.T) {
+	torrent := &transmission.Torrent{}
+	err := json.Unmarshal([]byte(`{"name": "Show.S01E02.1080p", "downloadDir": "/downloads/tv",
+		"trackers": [{"announce": "https://tracker.example.org:443/announce"}]}`), torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		`name:show`:                   true,
+		`name:/S\d\dE\d\d/`:           true,
+		`name:/^S\d\d/`:               false,
+		`tracker:example.org`:         true,
+		`tracker:tracker.example.org`: true,
+		`tracker:ample.org`:           false,
+		`dir:/downloads`:              true,
+		`dir:/downloads/`:             true,
+		`dir:/downloads/tv`:           true,
+		`dir:/down`:                   false,
+		`dir:/downloads/t`:            false,
+		`dir:/movies`:                 false,
+	}
+	for rule, expected := range cases {
+		r, err := parseTorrentRule(rule)
+		if err != nil {
+			t.Fatalf("%s: %s", rule, err)
+		}
+		if r.match(torrent) != expected {
+			t.Errorf("%s matches: %v", rule, !expected)
+		}
+	}
+
+	for _, rule := range []string{"show", "size:10", "name:", "name:/(/"} {
+		if _, err := parseTorrentRule(rule); err == nil {
+			t.Errorf("%s is parsed", rule)
+		}
+	}
+
+	if !matchAnyRule(nil, torrent) || !matchAnyRule([]string{"dir:/movies", "name:show"}, torrent) || matchAnyRule([]string{"dir:/movies"}, torrent) {
+		t.Error("Wrong matchAnyRule result")
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	scheduleCheckInterval = 30 * time.Second
+	// runs missed by the bot being stopped for longer are skipped
+	scheduleGrace = 5 * time.Minute
+)
+
+var (
+	cronMonths   = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	cronWeekdays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// cronSpec is a parsed cron spec, the fields are bit sets of the allowed values
+type cronSpec struct {
+	minutes, hours, days, months, weekdays uint64
+	// days and weekdays are ORed like in cron when both are restricted
+	anyDay, anyWeekday bool
+}
+
+// parseCron parses a spec of five fields: minute, hour, day of month, month and day of week.
+// Fields take *, numbers, ranges like 1-5, lists like 1,3 and steps like */15, months and days of week take names too.
+func parseCron(spec string) (cronSpec, error) {
+	var c cronSpec
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return c, fmt.Errorf("cron spec needs 5 fields: minute hour day month weekday")
+	}
+
+	var err error
+	if c.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return c, err
+	}
+	if c.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return c, err
+	}
+	if c.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return c, err
+	}
+	if c.months, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return c, err
+	}
+	// 7 is Sunday too
+	if c.weekdays, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return c, err
+	}
+	if c.weekdays&(1<<7) != 0 {
+		c.weekdays |= 1
+	}
+	c.anyDay = fields[2] == "*"
+	c.anyWeekday = fields[4] == "*"
+	return c, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("wrong value %q, expected %d-%d", s, min, max)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("wrong step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = value(bounds[0]); err != nil {
+				return 0, err
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// 5/10 means from 5 to the end
+				to = max
+			}
+			if to < from {
+				return 0, fmt.Errorf("wrong range %q", part)
+			}
+		}
+		for n := from; n <= to; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+func (c cronSpec) matches(t time.Time) bool {
+	has := func(bits uint64, n int) bool { return bits&(1<<uint(n)) != 0 }
+	if !has(c.minutes, t.Minute()) || !has(c.hours, t.Hour()) || !has(c.months, int(t.Month())) {
+		return false
+	}
+	day, weekday := has(c.days, t.Day()), has(c.weekdays, int(t.Weekday()))
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// dueRun returns the latest time matching the spec after the last run, runs older than scheduleGrace are skipped
+func dueRun(c cronSpec, lastRun time.Time, now time.Time) (time.Time, bool) {
+	for t := now.Truncate(time.Minute); t.After(lastRun) && now.Sub(t) < scheduleGrace; t = t.Add(-time.Minute) {
+		if c.matches(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// runsAfter returns the time the due run of the schedule is searched after, a new schedule
+// doesn't run for the cron times before it's added
+func runsAfter(schedule settings.Schedule) time.Time {
+	if schedule.Added.After(schedule.LastRun) {
+		return schedule.Added
+	}
+	return schedule.LastRun
+}
+
+// runSchedules runs the scheduled commands on their cron times
+func runSchedules(bot telegramClient, client torrentClient, auth *authorizer, s settings.Settings) {
+	for {
+		time.Sleep(scheduleCheckInterval)
+
+		schedules, err := s.GetSchedules()
+		if err != nil {
+			log.Println("GetSchedules failed:", err.Error())
+			continue
+		}
+		now := time.Now()
+		for _, schedule := range schedules {
+			spec, err := parseCron(schedule.Spec)
+			if err != nil {
+				log.Printf("[ERROR] Schedule %d: %s", schedule.ID, err)
+				continue
+			}
+			prefs, err := s.GetUserNotifications(strconv.Itoa(schedule.UserID))
+			if err != nil {
+				log.Println("GetUserNotifications failed:", err.Error())
+				continue
+			}
+			// the cron times are in the time zone of the user
+			run, ok := dueRun(spec, runsAfter(schedule), now.In(location(prefs)))
+			if !ok {
+				continue
+			}
+			if err := s.SetScheduleRun(schedule.ID, run); err != nil {
+				log.Println("SetScheduleRun failed:", err.Error())
+				continue
+			}
+			go runSchedule(bot, client, auth, s, schedule)
+		}
+	}
+}
+
+// runSchedule runs the command like it's sent by the user who created the schedule, with their current role
+func runSchedule(bot telegramClient, client torrentClient, auth *authorizer, s settings.Settings, schedule settings.Schedule) {
+	chatID := int64(schedule.UserID)
+	defer func() {
+		if recover() != nil {
+			send(bot, "PANIC: something goes wrong...", chatID, roleNone)
+			log.Println(string(debug.Stack()))
+		}
+	}()
+
+	r := auth.storedRole(strconv.Itoa(schedule.UserID))
+	if r == roleNone {
+		log.Printf("[INFO] Skipped schedule %d of the user %d without a role", schedule.ID, schedule.UserID)
+		return
+	}
+
+	msg := tgbotapi.Message{
+		From: &tgbotapi.User{ID: schedule.UserID, UserName: schedule.Username},
+		Chat: &tgbotapi.Chat{ID: chatID, Type: "private"},
+		Date: int(time.Now().Unix()),
+		Text: schedule.Command,
+	}
+	wrapper := wrapMessage(&msg, "")
+	wrapper.role = r
+
+	send(bot, fmt.Sprintf("<b>schedule</b> <b>%d</b>: <code>%s</code>", schedule.ID, escape(schedule.Command)), chatID, roleNone)
+	runAudited(findHandler(wrapper.Command(), wrapper.Role()), bot, client, wrapper, s)
+}
+
+// schedule manages scheduled commands: schedule add "0 8 * * 1-5" stop all, schedule list and schedule rm <id>
+func schedule(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	tokens := ud.Tokens()
+	command := "list"
+	if len(tokens) > 0 {
+		command = strings.ToLower(tokens[0])
+		tokens = tokens[1:]
+	}
+
+	switch command {
+	case "list", "ls":
+		listSchedules(bot, ud, s)
+	case "add":
+		addSchedule(bot, ud, s, strings.Join(tokens, " "))
+	case "rm", "remove", "del":
+		removeSchedules(bot, ud, s, tokens)
+	default:
+		send(bot, fmt.Sprintf("<b>schedule</b>: Unknown argument <code>%s</code>, use add, list or rm", escape(command)), ud.Chat.ID, ud.Role())
+	}
+}
+
+// splitSchedule splits "0 8 * * 1-5" stop all to the spec and the command, the spec may be unquoted
+func splitSchedule(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, `"`) {
+		if end := strings.Index(text[1:], `"`); end >= 0 {
+			return text[1 : end+1], strings.TrimSpace(text[end+2:])
+		}
+		return "", ""
+	}
+	fields := strings.Fields(text)
+	if len(fields) < 5 {
+		return "", ""
+	}
+	return strings.Join(fields[:5], " "), strings.Join(fields[5:], " ")
+}
+
+func addSchedule(bot telegramClient, ud messageWrapper, s settings.Settings, text string) {
+	spec, command := splitSchedule(text)
+	if spec == "" || command == "" {
+		send(bot, `<b>schedule add</b>: takes a cron spec and a command, e.g. <code>schedule add "0 8 * * 1-5" stop all</code>`, ud.Chat.ID, ud.Role())
+		return
+	}
+	if _, err := parseCron(spec); err != nil {
+		send(bot, fmt.Sprintf("<b>schedule add</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	name := strings.ToLower(strings.Fields(command)[0])
+	handler, required := lookupCommand(name)
+	switch {
+	case handler == nil:
+		send(bot, fmt.Sprintf("<b>schedule add</b>: no such command <code>%s</code>", escape(name)), ud.Chat.ID, ud.Role())
+		return
+	case strings.TrimPrefix(name, "/") == "schedule":
+		send(bot, "<b>schedule add</b>: schedules can't be scheduled", ud.Chat.ID, ud.Role())
+		return
+	case ud.Role() < required:
+		send(bot, fmt.Sprintf("<b>schedule add</b>: <code>%s</code> is not allowed for the <i>%s</i> role", escape(name), ud.Role()), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	id, err := s.AddSchedule(settings.Schedule{Spec: spec, Command: command, UserID: ud.From.ID, Username: ud.From.UserName, Added: time.Now()})
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>schedule add</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, fmt.Sprintf("<b>schedule add</b>: <b>%d</b> <code>%s</code> runs <code>%s</code>, the output is sent to you",
+		id, escape(spec), escape(command)), ud.Chat.ID, ud.Role())
+}
+
+// listSchedules sends the schedules of the user, admins see all of them
+func listSchedules(bot telegramClient, ud messageWrapper, s settings.Settings) {
+	schedules, err := s.GetSchedules()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>schedule</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	for _, schedule := range schedules {
+		if schedule.UserID != ud.From.ID && ud.Role() < roleAdmin {
+			continue
+		}
+		lastRun := "never"
+		if !schedule.LastRun.IsZero() {
+			lastRun = schedule.LastRun.Format("Jan _2 15:04")
+		}
+		buf.WriteString(fmt.Sprintf("<b>%d</b> <code>%s</code> <code>%s</code>\n    by %s, last run: <i>%s</i>\n", schedule.ID,
+			escape(schedule.Spec), escape(schedule.Command),
+			escape(ownerName(settings.TorrentOwner{UserID: schedule.UserID, Username: schedule.Username})), lastRun))
+	}
+	if buf.Len() == 0 {
+		send(bot, `<b>schedule</b>: nothing is scheduled, add a command with <code>schedule add "0 8 * * 1-5" stop all</code>`, ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, buf.String(), ud.Chat.ID, ud.Role())
+}
+
+// removeSchedules removes the schedules by IDs, only admins can remove schedules of others
+func removeSchedules(bot telegramClient, ud messageWrapper, s settings.Settings, ids []string) {
+	if len(ids) == 0 {
+		send(bot, "<b>schedule rm</b>: needs a schedule ID", ud.Chat.ID, ud.Role())
+		return
+	}
+	schedules, err := s.GetSchedules()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>schedule rm</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	for _, id := range ids {
+		var found *settings.Schedule
+		for i := range schedules {
+			if strconv.Itoa(schedules[i].ID) == id {
+				found = &schedules[i]
+			}
+		}
+		switch {
+		case found == nil:
+			send(bot, fmt.Sprintf("<b>schedule rm</b>: no schedule <code>%s</code>", escape(id)), ud.Chat.ID, ud.Role())
+		case found.UserID != ud.From.ID && ud.Role() < roleAdmin:
+			send(bot, fmt.Sprintf("<b>schedule rm</b>: schedule <b>%d</b> is not yours", found.ID), ud.Chat.ID, ud.Role())
+		default:
+			if err := s.DeleteSchedule(found.ID); err != nil {
+				send(bot, fmt.Sprintf("<b>schedule rm</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+				continue
+			}
+			send(bot, fmt.Sprintf("<b>schedule rm</b>: <b>%d</b> <code>%s</code> is removed", found.ID, escape(found.Command)), ud.Chat.ID, ud.Role())
+		}
+	}
+}
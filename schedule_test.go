@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+func TestCronSpec(t *testing.T) {
+	// Wednesday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2020, time.March, day, hour, minute, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		spec    string
+		t       time.Time
+		matches bool
+	}{
+		{"0 8 * * 1-5", at(4, 8, 0), true},
+		{"0 8 * * 1-5", at(7, 8, 0), false},
+		{"0 8 * * 1-5", at(4, 8, 1), false},
+		{"*/15 * * * *", at(4, 13, 45), true},
+		{"*/15 * * * *", at(4, 13, 50), false},
+		{"30 22 * * sun,7", at(8, 22, 30), true},
+		{"0 0 1 * mon", at(1, 0, 0), true},
+		{"0 0 1 * mon", at(2, 0, 0), true},
+		{"0 0 1 * mon", at(3, 0, 0), false},
+		{"0 9-17/4 * mar *", at(4, 13, 0), true},
+		{"0 9-17/4 * mar *", at(4, 11, 0), false},
+	}
+	for _, c := range cases {
+		spec, err := parseCron(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %s", c.spec, err)
+		}
+		if spec.matches(c.t) != c.matches {
+			t.Errorf("%s matches %s: %v", c.spec, c.t.Format(time.ANSIC), !c.matches)
+		}
+	}
+
+	for _, spec := range []string{"0 8 * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%s is parsed", spec)
+		}
+	}
+}
+
+func TestDueRun(t *testing.T) {
+	spec, _ := parseCron("0 8 * * *")
+	eight := time.Date(2020, time.March, 4, 8, 0, 0, 0, time.UTC)
+
+	if run, ok := dueRun(spec, time.Time{}, eight.Add(30*time.Second)); !ok || !run.Equal(eight) {
+		t.Errorf("The run is not due: %v", run)
+	}
+	if _, ok := dueRun(spec, eight, eight.Add(time.Minute)); ok {
+		t.Error("The run is due twice")
+	}
+	if run, ok := dueRun(spec, time.Time{}, eight.Add(3*time.Minute)); !ok || !run.Equal(eight) {
+		t.Errorf("The recently missed run is not due: %v", run)
+	}
+	if _, ok := dueRun(spec, time.Time{}, eight.Add(time.Hour)); ok {
+		t.Error("The run missed long ago is due")
+	}
+
+	// the schedule is added just after its cron time
+	schedule := settings.Schedule{Added: eight.Add(3 * time.Minute)}
+	if run, ok := dueRun(spec, runsAfter(schedule), eight.Add(4*time.Minute)); ok {
+		t.Errorf("The run before the schedule is added is due: %v", run)
+	}
+	if run, ok := dueRun(spec, runsAfter(schedule), eight.Add(24*time.Hour)); !ok || !run.Equal(eight.Add(24*time.Hour)) {
+		t.Errorf("The next run is not due: %v", run)
+	}
+	schedule.LastRun = eight.Add(24 * time.Hour)
+	if _, ok := dueRun(spec, runsAfter(schedule), eight.Add(24*time.Hour+time.Minute)); ok {
+		t.Error("The run is due twice")
+	}
+}
+
+func TestSplitSchedule(t *testing.T) {
+	cases := []struct {
+		text, spec, command string
+	}{
+		{`"0 8 * * 1-5" stop all`, "0 8 * * 1-5", "stop all"},
+		{`0 8 * * 1-5 stop all`, "0 8 * * 1-5", "stop all"},
+		{`"0 8 * * 1-5 stop all`, "", ""},
+		{`0 8 *`, "", ""},
+	}
+	for _, c := range cases {
+		if spec, command := splitSchedule(c.text); spec != c.spec || command != c.command {
+			t.Errorf("splitSchedule(%q) = %q, %q", c.text, spec, command)
+		}
+	}
+}
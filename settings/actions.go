@@ -0,0 +1,70 @@
+package settings
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const actions_bucket = "transmission-telegram-actions"
+
+// CompletionAction is applied to the torrents matching its rule when they finish downloading
+type CompletionAction struct {
+	ID int `json:"id"`
+	// Kind is move or exec
+	Kind string `json:"kind"`
+	// Target is the directory the data is moved to or the name of the hook executed
+	Target string `json:"target"`
+	// Rule is a torrent rule like name:/S\d\dE\d\d/ or tracker:example.org, the action applies to all torrents if it's empty
+	Rule     string    `json:"rule,omitempty"`
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username,omitempty"`
+	Added    time.Time `json:"added"`
+}
+
+// AddCompletionAction stores a new action and returns its ID
+func (s *settings) AddCompletionAction(action CompletionAction) (int, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(actions_bucket))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		action.ID = int(seq)
+		data, err := json.Marshal(action)
+		if err != nil {
+			return err
+		}
+		return b.Put(sequenceKey(seq), data)
+	})
+	s.db.Sync()
+	return action.ID, err
+}
+
+func (s *settings) DeleteCompletionAction(id int) error {
+	return s.delete(actions_bucket, string(sequenceKey(uint64(id))))
+}
+
+// GetCompletionActions returns all the actions ordered by ID
+func (s *settings) GetCompletionActions() ([]CompletionAction, error) {
+	var result []CompletionAction
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(actions_bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var action CompletionAction
+			if err := json.Unmarshal(v, &action); err != nil {
+				return err
+			}
+			result = append(result, action)
+			return nil
+		})
+	})
+	return result, err
+}
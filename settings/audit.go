@@ -0,0 +1,120 @@
+package settings
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	audit_bucket = "transmission-telegram-audit"
+	// audit_count_bucket keeps the number of the records, counting them takes a walk over the whole bucket
+	audit_count_bucket = "transmission-telegram-audit-count"
+
+	// maxAuditRecords is the number of records kept in the audit log, the oldest ones are removed
+	maxAuditRecords = 10000
+)
+
+// AuditRecord describes an executed command
+type AuditRecord struct {
+	Time      time.Time      `json:"time"`
+	UserID    int            `json:"user_id"`
+	Username  string         `json:"username,omitempty"`
+	ChatID    int64          `json:"chat_id"`
+	Command   string         `json:"command"`
+	Arguments []string       `json:"arguments,omitempty"`
+	Torrents  []AuditTorrent `json:"torrents,omitempty"`
+	Outcome   string         `json:"outcome"`
+	Reply     string         `json:"reply,omitempty"`
+}
+
+// AuditTorrent is a torrent affected by a command
+type AuditTorrent struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+func (s *settings) AddAuditRecord(record AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(audit_bucket))
+		if err != nil {
+			return err
+		}
+		counts, err := tx.CreateBucketIfNotExists([]byte(audit_count_bucket))
+		if err != nil {
+			return err
+		}
+		var count int
+		if v := counts.Get([]byte(audit_bucket)); len(v) == 8 {
+			count = int(binary.BigEndian.Uint64(v))
+		} else {
+			// the log is counted once if it was written before the counter
+			count = b.Stats().KeyN
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := b.Put(sequenceKey(seq), data); err != nil {
+			return err
+		}
+		count++
+
+		// keys are ordered by the sequence, so the oldest records come first, they are collected
+		// before deleting as deleting moves the cursor
+		var expired [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && count-len(expired) > maxAuditRecords; k, _ = c.Next() {
+			expired = append(expired, append([]byte{}, k...))
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		count -= len(expired)
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(count))
+		return counts.Put([]byte(audit_bucket), value)
+	})
+	s.db.Sync()
+	return err
+}
+
+// GetAuditRecords returns up to n latest records, newest first. Records are filtered
+// by the user if userID is not 0, all the records are returned if n is 0.
+func (s *settings) GetAuditRecords(n int, userID int) ([]AuditRecord, error) {
+	var result []AuditRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(audit_bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && (n == 0 || len(result) < n); k, v = c.Prev() {
+			var record AuditRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if userID != 0 && record.UserID != userID {
+				continue
+			}
+			result = append(result, record)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,110 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	cleanup_bucket = "transmission-telegram-cleanup"
+	keep_bucket    = "transmission-telegram-keep"
+)
+
+// CleanupPolicy removes finished torrents matching any of its conditions, zero conditions are not checked
+type CleanupPolicy struct {
+	ID          int     `json:"id"`
+	Ratio       float64 `json:"ratio,omitempty"`
+	SeedingDays int     `json:"seeding_days,omitempty"`
+	IdleDays    int     `json:"idle_days,omitempty"`
+	// Scope is a torrent rule like tracker:example.org or dir:/downloads, the policy applies to all torrents if it's empty
+	Scope      string `json:"scope,omitempty"`
+	DeleteData bool   `json:"delete_data,omitempty"`
+	// Enabled policies remove torrents, new ones only report what they would remove
+	Enabled  bool      `json:"enabled,omitempty"`
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username,omitempty"`
+	Added    time.Time `json:"added"`
+}
+
+// AddCleanupPolicy stores a new policy and returns its ID
+func (s *settings) AddCleanupPolicy(policy CleanupPolicy) (int, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(cleanup_bucket))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		policy.ID = int(seq)
+		data, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		return b.Put(sequenceKey(seq), data)
+	})
+	s.db.Sync()
+	return policy.ID, err
+}
+
+// UpdateCleanupPolicy replaces the stored policy with the same ID
+func (s *settings) UpdateCleanupPolicy(policy CleanupPolicy) error {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(cleanup_bucket))
+		if err != nil {
+			return err
+		}
+		if b.Get(sequenceKey(uint64(policy.ID))) == nil {
+			return fmt.Errorf("no cleanup policy %d", policy.ID)
+		}
+		return b.Put(sequenceKey(uint64(policy.ID)), data)
+	})
+	s.db.Sync()
+	return err
+}
+
+func (s *settings) DeleteCleanupPolicy(id int) error {
+	return s.delete(cleanup_bucket, string(sequenceKey(uint64(id))))
+}
+
+// GetCleanupPolicies returns all the policies ordered by ID
+func (s *settings) GetCleanupPolicies() ([]CleanupPolicy, error) {
+	var result []CleanupPolicy
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(cleanup_bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var policy CleanupPolicy
+			if err := json.Unmarshal(v, &policy); err != nil {
+				return err
+			}
+			result = append(result, policy)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// SetKeptTorrent exempts the torrent from cleanup policies, torrents are stored by hash with their names
+func (s *settings) SetKeptTorrent(hash string, name string) error {
+	return s.set(keep_bucket, hash, name)
+}
+
+func (s *settings) DeleteKeptTorrent(hash string) error {
+	return s.delete(keep_bucket, hash)
+}
+
+// GetKeptTorrents returns names of the kept torrents by their hashes
+func (s *settings) GetKeptTorrents() (map[string]string, error) {
+	return s.all(keep_bucket)
+}
@@ -0,0 +1,52 @@
+package settings
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const digest_bucket = "transmission-telegram-digests"
+
+// Digest is a scheduled summary report of a user
+type Digest struct {
+	// Weekly digests are sent on the Weekday, daily ones every day
+	Weekly  bool         `json:"weekly"`
+	Weekday time.Weekday `json:"weekday"`
+	Hour    int          `json:"hour"`
+	Minute  int          `json:"minute"`
+
+	// LastSent is the end of the period covered by the previous digest,
+	// Downloaded and Uploaded are the cumulative stats at that moment
+	LastSent   time.Time `json:"last_sent"`
+	Downloaded uint64    `json:"downloaded"`
+	Uploaded   uint64    `json:"uploaded"`
+}
+
+func (s *settings) SetUserDigest(key string, digest Digest) error {
+	data, err := json.Marshal(digest)
+	if err != nil {
+		return err
+	}
+	return s.set(digest_bucket, key, string(data))
+}
+
+func (s *settings) DeleteUserDigest(key string) error {
+	return s.delete(digest_bucket, key)
+}
+
+// GetUserDigests returns digests of all the users by user IDs
+func (s *settings) GetUserDigests() (map[string]Digest, error) {
+	values, err := s.all(digest_bucket)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]Digest)
+	for k, v := range values {
+		var digest Digest
+		if err := json.Unmarshal([]byte(v), &digest); err != nil {
+			return nil, err
+		}
+		result[k] = digest
+	}
+	return result, nil
+}
@@ -0,0 +1,164 @@
+package settings
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	feeds_bucket      = "transmission-telegram-feeds"
+	feed_items_bucket = "transmission-telegram-feed-items"
+)
+
+// Feed is an RSS or Atom feed watched for new torrents
+type Feed struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// UserID and Username are of the user who added the feed, the torrents are added on their behalf
+	UserID   int        `json:"user_id"`
+	Username string     `json:"username,omitempty"`
+	Rules    []FeedRule `json:"rules,omitempty"`
+	Added    time.Time  `json:"added"`
+}
+
+// FeedRule selects the feed items to download
+type FeedRule struct {
+	// Pattern is a regular expression matched against item titles
+	Pattern string `json:"pattern"`
+	// MinSize and MaxSize bound the item size in bytes, zero values are unlimited
+	MinSize uint64 `json:"min_size,omitempty"`
+	MaxSize uint64 `json:"max_size,omitempty"`
+	// Episodes downloads every episode only once, even if it's published again
+	Episodes bool `json:"episodes,omitempty"`
+	// DownloadDir is the directory the torrents are downloaded to, the default one if it's empty
+	DownloadDir string `json:"download_dir,omitempty"`
+}
+
+// AddFeed stores a new feed and returns its ID
+func (s *settings) AddFeed(feed Feed) (int, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(feeds_bucket))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		feed.ID = int(seq)
+		data, err := json.Marshal(feed)
+		if err != nil {
+			return err
+		}
+		return b.Put(sequenceKey(seq), data)
+	})
+	s.db.Sync()
+	return feed.ID, err
+}
+
+// UpdateFeed replaces the stored feed with the same ID
+func (s *settings) UpdateFeed(feed Feed) error {
+	data, err := json.Marshal(feed)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(feeds_bucket))
+		if err != nil {
+			return err
+		}
+		if b.Get(sequenceKey(uint64(feed.ID))) == nil {
+			return fmt.Errorf("no feed %d", feed.ID)
+		}
+		return b.Put(sequenceKey(uint64(feed.ID)), data)
+	})
+	s.db.Sync()
+	return err
+}
+
+// DeleteFeed removes the feed and its seen items
+func (s *settings) DeleteFeed(id int) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(feeds_bucket))
+		if err != nil {
+			return err
+		}
+		if err := b.Delete(sequenceKey(uint64(id))); err != nil {
+			return err
+		}
+		items := tx.Bucket([]byte(feed_items_bucket))
+		if items == nil || items.Bucket([]byte(strconv.Itoa(id))) == nil {
+			return nil
+		}
+		return items.DeleteBucket([]byte(strconv.Itoa(id)))
+	})
+	s.db.Sync()
+	return err
+}
+
+// GetFeeds returns all the feeds ordered by ID
+func (s *settings) GetFeeds() ([]Feed, error) {
+	var result []Feed
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(feeds_bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var feed Feed
+			if err := json.Unmarshal(v, &feed); err != nil {
+				return err
+			}
+			result = append(result, feed)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// MarkFeedItem remembers the item of the feed, it returns false if the item was marked already.
+// Items are GUIDs of the feed entries or any other keys like episode numbers.
+func (s *settings) MarkFeedItem(feedID int, item string) (bool, error) {
+	var marked bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		items, err := tx.CreateBucketIfNotExists([]byte(feed_items_bucket))
+		if err != nil {
+			return err
+		}
+		b, err := items.CreateBucketIfNotExists([]byte(strconv.Itoa(feedID)))
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte(item)) != nil {
+			return nil
+		}
+		marked = true
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(time.Now().Unix()))
+		return b.Put([]byte(item), value)
+	})
+	s.db.Sync()
+	return marked, err
+}
+
+// UnmarkFeedItem forgets the item of the feed, so it's handled as a new one again
+func (s *settings) UnmarkFeedItem(feedID int, item string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		items := tx.Bucket([]byte(feed_items_bucket))
+		if items == nil {
+			return nil
+		}
+		b := items.Bucket([]byte(strconv.Itoa(feedID)))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(item))
+	})
+	s.db.Sync()
+	return err
+}
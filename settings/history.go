@@ -0,0 +1,80 @@
+package settings
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const history_bucket = "transmission-telegram-history-"
+
+// SpeedSample is an average of download and upload speeds over a period starting at Time
+type SpeedSample struct {
+	Time time.Time
+	Down uint64
+	Up   uint64
+}
+
+// AddSpeedSample stores the sample in the series with the resolution,
+// samples older than retention are removed
+func (s *settings) AddSpeedSample(resolution string, sample SpeedSample, retention time.Duration) error {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value, sample.Down)
+	binary.BigEndian.PutUint64(value[8:], sample.Up)
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(history_bucket + resolution))
+		if err != nil {
+			return err
+		}
+		if err := b.Put(timeKey(sample.Time), value); err != nil {
+			return err
+		}
+
+		// the keys are collected first, deleting moves the cursor to the next key
+		until := timeKey(sample.Time.Add(-retention))
+		var expired [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < string(until); k, _ = c.Next() {
+			expired = append(expired, append([]byte{}, k...))
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// GetSpeedSamples returns the samples of the series with the resolution since the time, oldest first
+func (s *settings) GetSpeedSamples(resolution string, since time.Time) ([]SpeedSample, error) {
+	var result []SpeedSample
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(history_bucket + resolution))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(timeKey(since)); k != nil; k, v = c.Next() {
+			if len(v) != 16 {
+				continue
+			}
+			result = append(result, SpeedSample{
+				Time: time.Unix(int64(binary.BigEndian.Uint64(k)), 0),
+				Down: binary.BigEndian.Uint64(v),
+				Up:   binary.BigEndian.Uint64(v[8:]),
+			})
+		}
+		return nil
+	})
+	return result, err
+}
+
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.Unix()))
+	return key
+}
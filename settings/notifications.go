@@ -0,0 +1,174 @@
+package settings
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	notify_events_bucket = "transmission-telegram-notify-events"
+	notify_queue_bucket  = "transmission-telegram-notify-queue"
+)
+
+// Notifications are the notification preferences of a user
+type Notifications struct {
+	// Events are enabled notification events by their names
+	Events map[string]bool `json:"events"`
+	// StallMinutes is how long a download has no progress before it's reported as stalled
+	StallMinutes int `json:"stall_minutes,omitempty"`
+
+	// notifications are queued during quiet hours, QuietStart and QuietEnd are minutes of the day in the TimeZone
+	Quiet      bool   `json:"quiet,omitempty"`
+	QuietStart int    `json:"quiet_start,omitempty"`
+	QuietEnd   int    `json:"quiet_end,omitempty"`
+	TimeZone   string `json:"time_zone,omitempty"`
+
+	// Rules limit notifications to the torrents matching any of them, like tracker:example.org
+	Rules []string `json:"rules,omitempty"`
+
+	// AllTorrents sends the finished event of the torrents added by other users too,
+	// by default it's sent only to the user who added the torrent
+	AllTorrents bool `json:"all_torrents,omitempty"`
+}
+
+// Enabled reports whether notifications of the event are enabled
+func (n Notifications) Enabled(event string) bool {
+	return n.Events[event]
+}
+
+// Disabled reports whether notifications of the event are turned off explicitly
+func (n Notifications) Disabled(event string) bool {
+	enabled, ok := n.Events[event]
+	return ok && !enabled
+}
+
+func (s *settings) SetUserNotifications(key string, notifications Notifications) error {
+	data, err := json.Marshal(notifications)
+	if err != nil {
+		return err
+	}
+	return s.set(notify_events_bucket, key, string(data))
+}
+
+// GetUserNotifications returns the notification preferences of a user,
+// users who only have the old on/off setting get the finished event
+func (s *settings) GetUserNotifications(key string) (Notifications, error) {
+	notifications := Notifications{Events: make(map[string]bool)}
+	v, err := s.get(notify_events_bucket, key)
+	if err != nil {
+		return notifications, err
+	}
+	if v == "" {
+		old, err := s.get(notify_bucket, key)
+		if err != nil || old == "" {
+			return notifications, err
+		}
+		notifications.Events["finished"], err = s.GetUserNotification(key)
+		return notifications, err
+	}
+	if err := json.Unmarshal([]byte(v), &notifications); err != nil {
+		return notifications, err
+	}
+	if notifications.Events == nil {
+		notifications.Events = make(map[string]bool)
+	}
+	return notifications, nil
+}
+
+// MigrateUserNotifications moves the old on/off settings stored by usernames to the user IDs,
+// the IDs are taken from the known users, the settings of unknown users are left as they are
+func (s *settings) MigrateUserNotifications() error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		notify, err := tx.CreateBucketIfNotExists([]byte(notify_bucket))
+		if err != nil {
+			return err
+		}
+		users, err := tx.CreateBucketIfNotExists([]byte(users_bucket))
+		if err != nil {
+			return err
+		}
+		// the keys are collected first, the bucket can't be changed while it's iterated
+		ids := make(map[string]string)
+		values := make(map[string]string)
+		err = notify.ForEach(func(k, v []byte) error {
+			username := string(k)
+			if _, err := strconv.ParseInt(username, 10, 64); err == nil {
+				return nil
+			}
+			id := users.Get(k)
+			if id == nil {
+				id = users.Get([]byte(strings.ToLower(username)))
+			}
+			if id != nil {
+				ids[username] = string(id)
+				values[username] = string(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for username, id := range ids {
+			// a setting already stored by the ID is newer
+			if notify.Get([]byte(id)) == nil {
+				if err := notify.Put([]byte(id), []byte(values[username])); err != nil {
+					return err
+				}
+			}
+			if err := notify.Delete([]byte(username)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.db.Sync()
+	return err
+}
+
+// QueueNotification stores a notification of a user to be delivered later
+func (s *settings) QueueNotification(key string, text string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		queues, err := tx.CreateBucketIfNotExists([]byte(notify_queue_bucket))
+		if err != nil {
+			return err
+		}
+		b, err := queues.CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(sequenceKey(seq), []byte(text))
+	})
+	s.db.Sync()
+	return err
+}
+
+// TakeQueuedNotifications returns the queued notifications of a user in order and removes them
+func (s *settings) TakeQueuedNotifications(key string) ([]string, error) {
+	var result []string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		queues := tx.Bucket([]byte(notify_queue_bucket))
+		if queues == nil {
+			return nil
+		}
+		b := queues.Bucket([]byte(key))
+		if b == nil {
+			return nil
+		}
+		if err := b.ForEach(func(k, v []byte) error {
+			result = append(result, string(v))
+			return nil
+		}); err != nil {
+			return err
+		}
+		return queues.DeleteBucket([]byte(key))
+	})
+	s.db.Sync()
+	return result, err
+}
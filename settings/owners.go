@@ -0,0 +1,54 @@
+package settings
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const owners_bucket = "transmission-telegram-owners"
+
+// TorrentOwner is the user who added a torrent, stored by the torrent info hash
+type TorrentOwner struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username,omitempty"`
+	Added    time.Time `json:"added"`
+}
+
+func (s *settings) SetTorrentOwner(hash string, owner TorrentOwner) error {
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	return s.set(owners_bucket, hash, string(data))
+}
+
+// GetTorrentOwner returns the owner of the torrent, nil if it's unknown
+func (s *settings) GetTorrentOwner(hash string) (*TorrentOwner, error) {
+	v, err := s.get(owners_bucket, hash)
+	if err != nil || v == "" {
+		return nil, err
+	}
+	owner := &TorrentOwner{}
+	return owner, json.Unmarshal([]byte(v), owner)
+}
+
+func (s *settings) DeleteTorrentOwner(hash string) error {
+	return s.delete(owners_bucket, hash)
+}
+
+// GetTorrentOwners returns the owners of all the torrents by info hashes
+func (s *settings) GetTorrentOwners() (map[string]TorrentOwner, error) {
+	values, err := s.all(owners_bucket)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]TorrentOwner)
+	for k, v := range values {
+		var owner TorrentOwner
+		if err := json.Unmarshal([]byte(v), &owner); err != nil {
+			return nil, err
+		}
+		result[k] = owner
+	}
+	return result, nil
+}
@@ -0,0 +1,42 @@
+package settings
+
+import "encoding/json"
+
+const quota_bucket = "transmission-telegram-quotas"
+
+// Quota limits the torrents added by a user, zero values are unlimited
+type Quota struct {
+	// MaxActive is the number of the user's torrents which are not stopped
+	MaxActive int `json:"max_active,omitempty"`
+	// MaxTotal is the total size of the user's torrents in bytes
+	MaxTotal uint64 `json:"max_total,omitempty"`
+	// MaxSingle is the size of one torrent in bytes
+	MaxSingle uint64 `json:"max_single,omitempty"`
+}
+
+// Unlimited reports whether the quota has no limits
+func (q Quota) Unlimited() bool {
+	return q.MaxActive == 0 && q.MaxTotal == 0 && q.MaxSingle == 0
+}
+
+// SetUserQuota stores the quota of a user, an unlimited quota is deleted
+func (s *settings) SetUserQuota(key string, quota Quota) error {
+	if quota.Unlimited() {
+		return s.delete(quota_bucket, key)
+	}
+	data, err := json.Marshal(quota)
+	if err != nil {
+		return err
+	}
+	return s.set(quota_bucket, key, string(data))
+}
+
+// GetUserQuota returns the quota of a user, it's unlimited if it's not set
+func (s *settings) GetUserQuota(key string) (Quota, error) {
+	var quota Quota
+	v, err := s.get(quota_bucket, key)
+	if err != nil || v == "" {
+		return quota, err
+	}
+	return quota, json.Unmarshal([]byte(v), &quota)
+}
@@ -0,0 +1,97 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const schedules_bucket = "transmission-telegram-schedules"
+
+// Schedule is a bot command run at the times of a cron spec
+type Schedule struct {
+	ID int `json:"id"`
+	// Spec is a cron spec with five fields: minute, hour, day of month, month and day of week
+	Spec string `json:"spec"`
+	// Command is the command text like "stop all"
+	Command string `json:"command"`
+	// UserID and Username are of the user who created the schedule, the command is run on their behalf
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username,omitempty"`
+	Added    time.Time `json:"added"`
+	// LastRun is the time of the last run, it's zero if the command hasn't run yet
+	LastRun time.Time `json:"last_run,omitempty"`
+}
+
+// AddSchedule stores a new schedule and returns its ID
+func (s *settings) AddSchedule(schedule Schedule) (int, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(schedules_bucket))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		schedule.ID = int(seq)
+		data, err := json.Marshal(schedule)
+		if err != nil {
+			return err
+		}
+		return b.Put(sequenceKey(seq), data)
+	})
+	s.db.Sync()
+	return schedule.ID, err
+}
+
+// SetScheduleRun records the last run of the schedule
+func (s *settings) SetScheduleRun(id int, run time.Time) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(schedules_bucket))
+		if err != nil {
+			return err
+		}
+		data := b.Get(sequenceKey(uint64(id)))
+		if data == nil {
+			return fmt.Errorf("no schedule %d", id)
+		}
+		var schedule Schedule
+		if err := json.Unmarshal(data, &schedule); err != nil {
+			return err
+		}
+		schedule.LastRun = run
+		if data, err = json.Marshal(schedule); err != nil {
+			return err
+		}
+		return b.Put(sequenceKey(uint64(id)), data)
+	})
+	s.db.Sync()
+	return err
+}
+
+func (s *settings) DeleteSchedule(id int) error {
+	return s.delete(schedules_bucket, string(sequenceKey(uint64(id))))
+}
+
+// GetSchedules returns all the schedules ordered by ID
+func (s *settings) GetSchedules() ([]Schedule, error) {
+	var result []Schedule
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(schedules_bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var schedule Schedule
+			if err := json.Unmarshal(v, &schedule); err != nil {
+				return err
+			}
+			result = append(result, schedule)
+			return nil
+		})
+	})
+	return result, err
+}
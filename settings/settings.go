@@ -4,11 +4,15 @@ import (
 	"github.com/boltdb/bolt"
 	// "log"
 	"strconv"
+	"time"
 )
 
 const (
-	users_bucket  = "transmission-telegram-users"
-	notify_bucket = "transmission-telegram-notify"
+	users_bucket     = "transmission-telegram-users"
+	notify_bucket    = "transmission-telegram-notify"
+	roles_bucket     = "transmission-telegram-roles"
+	invite_bucket    = "transmission-telegram-invites"
+	dashboard_bucket = "transmission-telegram-dashboards"
 )
 
 type Settings interface {
@@ -16,6 +20,55 @@ type Settings interface {
 	GetUserID(string) (int64, error)
 	SetUserNotification(string, bool) error
 	GetUserNotification(string) (bool, error)
+	SetUserNotifications(string, Notifications) error
+	GetUserNotifications(string) (Notifications, error)
+	MigrateUserNotifications() error
+	QueueNotification(string, string) error
+	TakeQueuedNotifications(string) ([]string, error)
+	SetUserRole(string, string) error
+	GetUserRole(string) (string, error)
+	DeleteUserRole(string) error
+	GetUserRoles() (map[string]string, error)
+	SetInvite(string, string) error
+	TakeInvite(string) (string, error)
+	AddAuditRecord(AuditRecord) error
+	GetAuditRecords(n int, userID int) ([]AuditRecord, error)
+	SetDashboard(int64, int) error
+	DeleteDashboard(int64) error
+	GetDashboards() (map[int64]int, error)
+	AddSpeedSample(resolution string, sample SpeedSample, retention time.Duration) error
+	GetSpeedSamples(resolution string, since time.Time) ([]SpeedSample, error)
+	SetUserDigest(string, Digest) error
+	DeleteUserDigest(string) error
+	GetUserDigests() (map[string]Digest, error)
+	SetTorrentStates(map[string]TorrentState) error
+	GetTorrentStates() (map[string]TorrentState, error)
+	SetTorrentOwner(string, TorrentOwner) error
+	GetTorrentOwner(string) (*TorrentOwner, error)
+	DeleteTorrentOwner(string) error
+	GetTorrentOwners() (map[string]TorrentOwner, error)
+	SetUserQuota(string, Quota) error
+	GetUserQuota(string) (Quota, error)
+	AddFeed(Feed) (int, error)
+	UpdateFeed(Feed) error
+	DeleteFeed(int) error
+	GetFeeds() ([]Feed, error)
+	MarkFeedItem(feedID int, item string) (bool, error)
+	UnmarkFeedItem(feedID int, item string) error
+	AddSchedule(Schedule) (int, error)
+	SetScheduleRun(id int, run time.Time) error
+	DeleteSchedule(int) error
+	GetSchedules() ([]Schedule, error)
+	AddCleanupPolicy(CleanupPolicy) (int, error)
+	UpdateCleanupPolicy(CleanupPolicy) error
+	DeleteCleanupPolicy(int) error
+	GetCleanupPolicies() ([]CleanupPolicy, error)
+	SetKeptTorrent(hash string, name string) error
+	DeleteKeptTorrent(hash string) error
+	GetKeptTorrents() (map[string]string, error)
+	AddCompletionAction(CompletionAction) (int, error)
+	DeleteCompletionAction(int) error
+	GetCompletionActions() ([]CompletionAction, error)
 	Close()
 }
 
@@ -60,6 +113,75 @@ func (s *settings) GetUserNotification(username string) (bool, error) {
 	return b, nil
 }
 
+// SetUserRole stores the role of a user, the key is the user ID or @username if the ID is unknown yet
+func (s *settings) SetUserRole(key string, role string) error {
+	return s.set(roles_bucket, key, role)
+}
+
+// GetUserRole returns the role of a user or an empty string
+func (s *settings) GetUserRole(key string) (string, error) {
+	return s.get(roles_bucket, key)
+}
+
+func (s *settings) DeleteUserRole(key string) error {
+	return s.delete(roles_bucket, key)
+}
+
+// GetUserRoles returns roles of all the users
+func (s *settings) GetUserRoles() (map[string]string, error) {
+	return s.all(roles_bucket)
+}
+
+// SetInvite stores a one-time invite code granting the role
+func (s *settings) SetInvite(code string, role string) error {
+	return s.set(invite_bucket, code, role)
+}
+
+// TakeInvite returns the role of the invite code and deletes the code, the role is empty for unknown codes
+func (s *settings) TakeInvite(code string) (string, error) {
+	var role string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(invite_bucket))
+		if err != nil {
+			return err
+		}
+		role = string(b.Get([]byte(code)))
+		return b.Delete([]byte(code))
+	})
+	s.db.Sync()
+	return role, err
+}
+
+// SetDashboard stores the ID of the dashboard message in the chat
+func (s *settings) SetDashboard(chatID int64, msgID int) error {
+	return s.set(dashboard_bucket, strconv.FormatInt(chatID, 10), strconv.Itoa(msgID))
+}
+
+func (s *settings) DeleteDashboard(chatID int64) error {
+	return s.delete(dashboard_bucket, strconv.FormatInt(chatID, 10))
+}
+
+// GetDashboards returns dashboard message IDs by chat IDs
+func (s *settings) GetDashboards() (map[int64]int, error) {
+	values, err := s.all(dashboard_bucket)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64]int)
+	for k, v := range values {
+		chatID, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		msgID, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		result[chatID] = msgID
+	}
+	return result, nil
+}
+
 func (s *settings) set(bucket string, key string, value string) error {
 	err := s.db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
@@ -72,6 +194,18 @@ func (s *settings) set(bucket string, key string, value string) error {
 	return err
 }
 
+func (s *settings) delete(bucket string, key string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Delete([]byte(key))
+	})
+	s.db.Sync()
+	return err
+}
+
 func (s *settings) get(bucket string, key string) (string, error) {
 	var result string
 	err := s.db.Update(func(tx *bolt.Tx) error {
@@ -85,6 +219,21 @@ func (s *settings) get(bucket string, key string) (string, error) {
 	return result, err
 }
 
+func (s *settings) all(bucket string) (map[string]string, error) {
+	result := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			result[string(k)] = string(v)
+			return nil
+		})
+	})
+	return result, err
+}
+
 func (s *settings) Close() {
 	s.db.Close()
 }
@@ -3,7 +3,9 @@ package settings_test
 import (
 	"github.com/zhulik/transmission-telegram/settings"
 	"os"
+	"strconv"
 	"testing"
+	"time"
 )
 
 const (
@@ -88,3 +90,619 @@ func TestGetNotification(t *testing.T) {
 	}
 	settings.Close()
 }
+
+func TestUserRoles(t *testing.T) {
+	os.Remove(path)
+	settings, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role, err := settings.GetUserRole("100500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "" {
+		t.Fatal("Wrong role returned")
+	}
+
+	if err = settings.SetUserRole("100500", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err = settings.SetUserRole("@testuser", "viewer"); err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := settings.GetUserRoles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 2 || roles["100500"] != "admin" || roles["@testuser"] != "viewer" {
+		t.Fatal("Wrong roles returned")
+	}
+
+	if err = settings.DeleteUserRole("@testuser"); err != nil {
+		t.Fatal(err)
+	}
+	role, err = settings.GetUserRole("@testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "" {
+		t.Fatal("Role is not deleted")
+	}
+	settings.Close()
+}
+
+func TestInvite(t *testing.T) {
+	os.Remove(path)
+	settings, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = settings.SetInvite("code", "viewer"); err != nil {
+		t.Fatal(err)
+	}
+
+	role, err := settings.TakeInvite("code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "viewer" {
+		t.Fatal("Wrong role returned")
+	}
+
+	role, err = settings.TakeInvite("code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "" {
+		t.Fatal("Invite is used twice")
+	}
+	settings.Close()
+}
+
+func TestAuditRecordsLimit(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the log keeps the latest 10000 records
+	for i := 0; i < 10002; i++ {
+		if err = s.AddAuditRecord(settings.AuditRecord{UserID: 1, Command: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := s.GetAuditRecords(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 10000 || records[0].Command != "10001" || records[9999].Command != "2" {
+		t.Fatalf("Wrong records are kept: %d", len(records))
+	}
+	s.Close()
+}
+
+func TestAuditRecords(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, user := range []int{1, 2, 1} {
+		err = s.AddAuditRecord(settings.AuditRecord{UserID: user, Command: "list", Arguments: []string{strconv.Itoa(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := s.GetAuditRecords(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Arguments[0] != "2" {
+		t.Fatal("Wrong records returned")
+	}
+
+	records, err = s.GetAuditRecords(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Arguments[0] != "2" {
+		t.Fatal("Wrong records returned")
+	}
+
+	records, err = s.GetAuditRecords(5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].UserID != 2 {
+		t.Fatal("Wrong records returned")
+	}
+	s.Close()
+}
+
+func TestDashboards(t *testing.T) {
+	os.Remove(path)
+	settings, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = settings.SetDashboard(-100500, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err = settings.SetDashboard(100500, 43); err != nil {
+		t.Fatal(err)
+	}
+	if err = settings.DeleteDashboard(100500); err != nil {
+		t.Fatal(err)
+	}
+
+	dashboards, err := settings.GetDashboards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dashboards) != 1 || dashboards[-100500] != 42 {
+		t.Fatal("Wrong dashboards returned")
+	}
+	settings.Close()
+}
+
+func TestSpeedSamples(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1000000, 0)
+	for i := 0; i < 10; i++ {
+		sample := settings.SpeedSample{Time: start.Add(time.Duration(i) * time.Minute), Down: uint64(i), Up: uint64(i * 2)}
+		if err = s.AddSpeedSample("1m", sample, 5*time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples, err := s.GetSpeedSamples("1m", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 6 || samples[0].Down != 4 || samples[5].Up != 18 {
+		t.Fatal("Wrong samples returned")
+	}
+
+	samples, err = s.GetSpeedSamples("1m", start.Add(8*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || !samples[0].Time.Equal(start.Add(8*time.Minute)) {
+		t.Fatal("Wrong samples returned")
+	}
+
+	// all the expired samples are removed at once
+	if err = s.AddSpeedSample("1m", settings.SpeedSample{Time: start.Add(time.Hour)}, 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	samples, err = s.GetSpeedSamples("1m", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("Expired samples are kept: %d", len(samples))
+	}
+	s.Close()
+}
+
+func TestUserDigests(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := settings.Digest{Weekly: true, Weekday: time.Monday, Hour: 9, LastSent: time.Unix(1000000, 0), Uploaded: 42}
+	if err = s.SetUserDigest("100500", digest); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetUserDigest("100501", digest); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteUserDigest("100501"); err != nil {
+		t.Fatal(err)
+	}
+
+	digests, err := s.GetUserDigests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, ok := digests["100500"]
+	if len(digests) != 1 || !ok || !stored.Weekly || stored.Weekday != time.Monday || stored.Uploaded != 42 || !stored.LastSent.Equal(digest.LastSent) {
+		t.Fatal("Wrong digests returned")
+	}
+	s.Close()
+}
+
+func TestUserNotifications(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.SetUserNotification("100500", true); err != nil {
+		t.Fatal(err)
+	}
+	notifications, err := s.GetUserNotifications("100500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notifications.Enabled("finished") || notifications.Enabled("added") {
+		t.Fatal("Old setting is not used")
+	}
+
+	notifications.Events["added"] = true
+	notifications.Events["finished"] = false
+	notifications.StallMinutes = 15
+	if err = s.SetUserNotifications("100500", notifications); err != nil {
+		t.Fatal(err)
+	}
+	notifications, err = s.GetUserNotifications("100500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notifications.Enabled("added") || notifications.Enabled("finished") || notifications.StallMinutes != 15 {
+		t.Fatalf("Wrong notifications returned: %+v", notifications)
+	}
+	s.Close()
+}
+
+func TestMigrateUserNotifications(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetUserID("Olduser", 100500)
+	s.SetUserNotification("Olduser", true)
+	s.SetUserNotification("stranger", true)
+	if err = s.MigrateUserNotifications(); err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, err := s.GetUserNotifications("100500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notifications.Enabled("finished") {
+		t.Fatal("Setting stored by username is not migrated")
+	}
+	if old, _ := s.GetUserNotification("Olduser"); old {
+		t.Fatal("Setting stored by username is not removed")
+	}
+	if old, _ := s.GetUserNotification("stranger"); !old {
+		t.Fatal("Setting of unknown user is removed")
+	}
+	s.Close()
+}
+
+func TestNotificationQueue(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, text := range []string{"first", "second", "third"} {
+		if err = s.QueueNotification("100500", text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.QueueNotification("100501", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := s.TakeQueuedNotifications("100500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 3 || queued[0] != "first" || queued[2] != "third" {
+		t.Fatalf("Wrong notifications returned: %v", queued)
+	}
+	if queued, err = s.TakeQueuedNotifications("100500"); err != nil || len(queued) != 0 {
+		t.Fatal("Notifications are not removed")
+	}
+	if queued, err = s.TakeQueuedNotifications("100501"); err != nil || len(queued) != 1 {
+		t.Fatal("Notifications of another user are removed")
+	}
+	s.Close()
+}
+
+func TestTorrentStates(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.SetTorrentStates(map[string]settings.TorrentState{
+		"aaa": {ID: 1, Name: "first", Status: 4, PercentDone: 0.5},
+		"bbb": {ID: 2, Name: "second", Status: 6, PercentDone: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetTorrentStates(map[string]settings.TorrentState{
+		"aaa": {ID: 1, Name: "first", Status: 6, PercentDone: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := s.GetTorrentStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 || states["aaa"].Status != 6 || states["aaa"].PercentDone != 1 {
+		t.Fatalf("Wrong states returned: %+v", states)
+	}
+	s.Close()
+}
+
+func TestTorrentOwners(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := s.GetTorrentOwner("aaa")
+	if err != nil || owner != nil {
+		t.Fatal("Unknown owner is returned")
+	}
+	if err = s.SetTorrentOwner("aaa", settings.TorrentOwner{UserID: 100500, Username: "user", Added: time.Unix(1000, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetTorrentOwner("bbb", settings.TorrentOwner{UserID: 100501}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteTorrentOwner("bbb"); err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err = s.GetTorrentOwner("aaa")
+	if err != nil || owner == nil || owner.UserID != 100500 || owner.Username != "user" {
+		t.Fatalf("Wrong owner returned: %+v", owner)
+	}
+	owners, err := s.GetTorrentOwners()
+	if err != nil || len(owners) != 1 || owners["aaa"].UserID != 100500 {
+		t.Fatalf("Wrong owners returned: %+v", owners)
+	}
+	s.Close()
+}
+
+func TestUserQuota(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quota, err := s.GetUserQuota("100500")
+	if err != nil || !quota.Unlimited() {
+		t.Fatal("Quota is limited by default")
+	}
+	if err = s.SetUserQuota("100500", settings.Quota{MaxActive: 3, MaxSingle: 1 << 30}); err != nil {
+		t.Fatal(err)
+	}
+	quota, err = s.GetUserQuota("100500")
+	if err != nil || quota.MaxActive != 3 || quota.MaxSingle != 1<<30 || quota.MaxTotal != 0 {
+		t.Fatalf("Wrong quota returned: %+v", quota)
+	}
+
+	if err = s.SetUserQuota("100500", settings.Quota{}); err != nil {
+		t.Fatal(err)
+	}
+	quota, err = s.GetUserQuota("100500")
+	if err != nil || !quota.Unlimited() {
+		t.Fatal("Quota is not removed")
+	}
+	s.Close()
+}
+
+func TestFeeds(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.AddFeed(settings.Feed{URL: "http://example.org/first", UserID: 100500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.AddFeed(settings.Feed{URL: "http://example.org/second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("Feeds have the same IDs")
+	}
+
+	err = s.UpdateFeed(settings.Feed{ID: first, URL: "http://example.org/first", Rules: []settings.FeedRule{{Pattern: "show", Episodes: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.UpdateFeed(settings.Feed{ID: 42}); err == nil {
+		t.Fatal("Unknown feed is updated")
+	}
+
+	if marked, err := s.MarkFeedItem(second, "guid"); err != nil || !marked {
+		t.Fatal("Item is not marked")
+	}
+	if marked, err := s.MarkFeedItem(second, "guid"); err != nil || marked {
+		t.Fatal("Item is marked twice")
+	}
+	if marked, err := s.MarkFeedItem(first, "guid"); err != nil || !marked {
+		t.Fatal("Item of another feed is marked")
+	}
+	if err = s.UnmarkFeedItem(first, "guid"); err != nil {
+		t.Fatal(err)
+	}
+	if marked, err := s.MarkFeedItem(first, "guid"); err != nil || !marked {
+		t.Fatal("Unmarked item is not marked again")
+	}
+	if err = s.UnmarkFeedItem(42, "guid"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.DeleteFeed(second); err != nil {
+		t.Fatal(err)
+	}
+	feeds, err := s.GetFeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 1 || feeds[0].ID != first || len(feeds[0].Rules) != 1 || !feeds[0].Rules[0].Episodes {
+		t.Fatalf("Wrong feeds returned: %+v", feeds)
+	}
+	s.Close()
+}
+
+func TestSchedules(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.AddSchedule(settings.Schedule{Spec: "0 8 * * 1-5", Command: "stop all", UserID: 100500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.AddSchedule(settings.Schedule{Spec: "0 18 * * 1-5", Command: "start all", UserID: 100500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := time.Date(2020, time.March, 4, 8, 0, 0, 0, time.UTC)
+	if err = s.SetScheduleRun(first, run); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetScheduleRun(42, run); err == nil {
+		t.Fatal("Run of an unknown schedule is recorded")
+	}
+	if err = s.DeleteSchedule(second); err != nil {
+		t.Fatal(err)
+	}
+
+	schedules, err := s.GetSchedules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || schedules[0].ID != first || schedules[0].Command != "stop all" || !schedules[0].LastRun.Equal(run) {
+		t.Fatalf("Wrong schedules returned: %+v", schedules)
+	}
+	s.Close()
+}
+
+func TestCleanupPolicies(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.AddCleanupPolicy(settings.CleanupPolicy{Ratio: 2, Scope: "tracker:example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.AddCleanupPolicy(settings.CleanupPolicy{IdleDays: 30, DeleteData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.UpdateCleanupPolicy(settings.CleanupPolicy{ID: first, Ratio: 2, Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.UpdateCleanupPolicy(settings.CleanupPolicy{ID: 42}); err == nil {
+		t.Fatal("Unknown policy is updated")
+	}
+	if err = s.DeleteCleanupPolicy(second); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := s.GetCleanupPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || policies[0].ID != first || !policies[0].Enabled || policies[0].Ratio != 2 {
+		t.Fatalf("Wrong policies returned: %+v", policies)
+	}
+
+	if err = s.SetKeptTorrent("aaa", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetKeptTorrent("bbb", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteKeptTorrent("bbb"); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := s.GetKeptTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || kept["aaa"] != "first" {
+		t.Fatalf("Wrong kept torrents returned: %v", kept)
+	}
+	s.Close()
+}
+
+func TestCompletionActions(t *testing.T) {
+	os.Remove(path)
+	s, err := settings.GetSettings(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.AddCompletionAction(settings.CompletionAction{Kind: "move", Target: "/downloads/tv", Rule: "name:/S\\d\\dE\\d\\d/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.AddCompletionAction(settings.CompletionAction{Kind: "exec", Target: "scan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteCompletionAction(first); err != nil {
+		t.Fatal(err)
+	}
+	actions, err := s.GetCompletionActions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].ID != second || actions[0].Target != "scan" {
+		t.Fatalf("Wrong actions returned: %+v", actions)
+	}
+	s.Close()
+}
@@ -0,0 +1,61 @@
+package settings
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+const torrents_bucket = "transmission-telegram-torrents"
+
+// TorrentState is the last known state of a torrent, stored by its info hash
+type TorrentState struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Status      int     `json:"status"`
+	PercentDone float64 `json:"percent_done"`
+}
+
+// SetTorrentStates replaces all the stored torrent states
+func (s *settings) SetTorrentStates(states map[string]TorrentState) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(torrents_bucket)) != nil {
+			if err := tx.DeleteBucket([]byte(torrents_bucket)); err != nil {
+				return err
+			}
+		}
+		b, err := tx.CreateBucket([]byte(torrents_bucket))
+		if err != nil {
+			return err
+		}
+		for hash, state := range states {
+			data, err := json.Marshal(state)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(hash), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.db.Sync()
+	return err
+}
+
+// GetTorrentStates returns the stored torrent states by info hashes
+func (s *settings) GetTorrentStates() (map[string]TorrentState, error) {
+	values, err := s.all(torrents_bucket)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]TorrentState)
+	for k, v := range values {
+		var state TorrentState
+		if err := json.Unmarshal([]byte(v), &state); err != nil {
+			return nil, err
+		}
+		result[k] = state
+	}
+	return result, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+// statesSaveInterval limits how often changed progress is saved, status changes are saved at once
+const statesSaveInterval = time.Minute
+
+// torrentStates keeps the last known torrent states in the settings by info hashes,
+// so the changes made while the bot wasn't running can be found
+type torrentStates struct {
+	client torrentClient
+	s      settings.Settings
+
+	hashes  map[int]string
+	names   map[int]string
+	saved   map[string]settings.TorrentState
+	savedAt time.Time
+}
+
+func newTorrentStates(client torrentClient, s settings.Settings) *torrentStates {
+	return &torrentStates{client: client, s: s, hashes: make(map[int]string), names: make(map[int]string)}
+}
+
+// updateHashes fetches the info hashes when there are torrents it hasn't seen yet, a torrent with
+// a known ID but another name is new too, the IDs are reassigned when the daemon restarts
+func (ts *torrentStates) updateHashes(torrents transmission.Torrents) error {
+	unknown := false
+	for _, t := range torrents {
+		if _, ok := ts.hashes[t.ID]; !ok || ts.names[t.ID] != t.Name {
+			unknown = true
+			break
+		}
+	}
+	if !unknown {
+		return nil
+	}
+
+	details, err := ts.client.GetTorrentDetails()
+	if err != nil {
+		return err
+	}
+	for _, t := range torrents {
+		if d, ok := details[t.ID]; ok {
+			ts.hashes[t.ID] = d.HashString
+			ts.names[t.ID] = t.Name
+		}
+	}
+	return nil
+}
+
+// hash returns the info hash of the torrent, it's empty for unknown torrents
+func (ts *torrentStates) hash(id int) string {
+	return ts.hashes[id]
+}
+
+// prune forgets the hashes of the removed torrents
+func (ts *torrentStates) prune(torrents transmission.Torrents) {
+	current := make(map[int]bool, len(torrents))
+	for _, t := range torrents {
+		current[t.ID] = true
+	}
+	for id := range ts.hashes {
+		if !current[id] {
+			delete(ts.hashes, id)
+			delete(ts.names, id)
+		}
+	}
+}
+
+// missed returns the torrents which were finished since the states were saved
+func (ts *torrentStates) missed(torrents transmission.Torrents) (transmission.Torrents, error) {
+	if err := ts.updateHashes(torrents); err != nil {
+		return nil, err
+	}
+	saved, err := ts.s.GetTorrentStates()
+	if err != nil {
+		return nil, err
+	}
+	ts.saved = saved
+
+	var result transmission.Torrents
+	for _, t := range torrents {
+		state, ok := saved[ts.hashes[t.ID]]
+		if ok && state.PercentDone < 1 && t.PercentDone >= 1 {
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+// save stores the states of the torrents if they have changed
+func (ts *torrentStates) save(torrents transmission.Torrents, now time.Time) error {
+	if err := ts.updateHashes(torrents); err != nil {
+		return err
+	}
+	ts.prune(torrents)
+
+	states := make(map[string]settings.TorrentState, len(torrents))
+	changed := len(torrents) != len(ts.saved)
+	progressed := false
+	for _, t := range torrents {
+		hash, ok := ts.hashes[t.ID]
+		if !ok {
+			continue
+		}
+		state := settings.TorrentState{ID: t.ID, Name: t.Name, Status: t.Status, PercentDone: t.PercentDone}
+		states[hash] = state
+
+		old, ok := ts.saved[hash]
+		if !ok || old.Status != state.Status || (old.PercentDone < 1) != (state.PercentDone < 1) {
+			changed = true
+		} else if old.PercentDone != state.PercentDone {
+			progressed = true
+		}
+	}
+	if !changed && !(progressed && now.Sub(ts.savedAt) >= statesSaveInterval) {
+		return nil
+	}
+
+	if err := ts.s.SetTorrentStates(states); err != nil {
+		return err
+	}
+	ts.saved = states
+	ts.savedAt = now
+	return nil
+}
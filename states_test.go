@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pyed/transmission"
+)
+
+// fakeTorrentClient returns the torrents, the methods it doesn't override panic
+type fakeTorrentClient struct {
+	torrentClient
+	torrents transmission.Torrents
+	hashes   map[int]string
+
+	// active and removed are returned by GetRecentlyActive
+	active  transmission.Torrents
+	removed []int
+	// err fails the polls
+	err error
+}
+
+func (c *fakeTorrentClient) GetRecentlyActive() (transmission.Torrents, []int, error) {
+	return c.active, c.removed, c.err
+}
+
+func (c *fakeTorrentClient) GetTorrents() (transmission.Torrents, error) {
+	return c.torrents, c.err
+}
+
+func (c *fakeTorrentClient) GetTorrentDetails() (map[int]*torrentDetails, error) {
+	details := make(map[int]*torrentDetails)
+	for id, hash := range c.hashes {
+		details[id] = &torrentDetails{ID: id, HashString: hash}
+	}
+	return details, nil
+}
+
+func TestTorrentStatesMissed(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	client := &fakeTorrentClient{hashes: map[int]string{1: "aaa", 2: "bbb"}}
+
+	before := transmission.Torrents{
+		{ID: 1, Status: transmission.StatusDownloading, PercentDone: 0.5},
+		{ID: 2, Status: transmission.StatusDownloading, PercentDone: 0.1},
+	}
+	if err := newTorrentStates(client, s).save(before, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the daemon has restarted while the bot was stopped, so the IDs have changed
+	client.hashes = map[int]string{5: "bbb", 6: "aaa", 7: "ccc"}
+	after := transmission.Torrents{
+		{ID: 5, Status: transmission.StatusDownloading, PercentDone: 0.2},
+		{ID: 6, Status: transmission.StatusSeeding, PercentDone: 1},
+		{ID: 7, Status: transmission.StatusSeeding, PercentDone: 1},
+	}
+	missed, err := newTorrentStates(client, s).missed(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missed) != 1 || missed[0].ID != 6 {
+		t.Fatalf("Wrong missed torrents: %+v", missed)
+	}
+}
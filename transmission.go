@@ -1,9 +1,180 @@
 package main
 
-import "github.com/pyed/transmission"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/pyed/transmission"
+)
 
 type transmissionClient struct {
 	client *transmission.TransmissionClient
+	// rpc is used for the methods transmission.TransmissionClient doesn't support
+	rpc *transmission.ApiClient
+}
+
+// session is a subset of transmission session settings
+type session struct {
+	DownloadDir     string `json:"download-dir"`
+	AltSpeedEnabled bool   `json:"alt-speed-enabled"`
+	AltSpeedDown    int    `json:"alt-speed-down"`
+	AltSpeedUp      int    `json:"alt-speed-up"`
+}
+
+// torrentDetails holds the torrent fields transmission.Torrent lacks
+type torrentDetails struct {
+	ID         int    `json:"id"`
+	HashString string `json:"hashString"`
+	DoneDate   int64  `json:"doneDate"`
+	// SecondsSeeding is the total seeding time, ActivityDate is the time of the last transfer
+	SecondsSeeding int64 `json:"secondsSeeding"`
+	ActivityDate   int64 `json:"activityDate"`
+}
+
+// torrentFields are the fields of transmission.Torrent
+var torrentFields = []string{"id", "name",
+	"status", "addedDate", "leftUntilDone", "sizeWhenDone", "eta", "uploadRatio", "uploadedEver",
+	"rateDownload", "rateUpload", "downloadDir", "haveValid", "haveUnchecked", "isFinished", "downloadedEver",
+	"percentDone", "seedRatioMode", "error", "errorString", "trackers"}
+
+func newTransmissionClient(url string, username string, password string) (transmissionClient, error) {
+	client, err := transmission.New(url, username, password)
+	if err != nil {
+		return transmissionClient{}, err
+	}
+	return transmissionClient{client: client, rpc: transmission.NewClient(url, username, password)}, nil
+}
+
+// isConnectionError reports whether transmission couldn't be reached, unlike the errors it returns itself
+func isConnectionError(err error) bool {
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// call executes an RPC method and decodes its arguments to the result
+func (client transmissionClient) call(method string, arguments interface{}, result interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"method": method, "arguments": arguments})
+	if err != nil {
+		return err
+	}
+	out, err := client.rpc.Post(string(body))
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Result    string          `json:"result"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(out, &response); err != nil {
+		return err
+	}
+	if response.Result != "success" {
+		return fmt.Errorf("%s: %s", method, response.Result)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Arguments, result)
+}
+
+func (client transmissionClient) GetSession() (*session, error) {
+	result := &session{}
+	err := client.call("session-get", map[string]interface{}{}, result)
+	return result, err
+}
+
+// GetTorrentDetails returns the extra fields of all the torrents by their IDs
+func (client transmissionClient) GetTorrentDetails() (map[int]*torrentDetails, error) {
+	var result struct {
+		Torrents []*torrentDetails `json:"torrents"`
+	}
+	err := client.call("torrent-get", map[string]interface{}{"fields": []string{"id", "hashString", "doneDate", "secondsSeeding", "activityDate"}}, &result)
+	if err != nil {
+		return nil, err
+	}
+	details := make(map[int]*torrentDetails, len(result.Torrents))
+	for _, t := range result.Torrents {
+		details[t.ID] = t
+	}
+	return details, nil
+}
+
+// GetRecentlyActive returns the torrents changed recently and the IDs of the removed ones
+func (client transmissionClient) GetRecentlyActive() (transmission.Torrents, []int, error) {
+	var result struct {
+		Torrents transmission.Torrents `json:"torrents"`
+		Removed  []int                 `json:"removed"`
+	}
+	err := client.call("torrent-get", map[string]interface{}{"ids": "recently-active", "fields": torrentFields}, &result)
+	return result.Torrents, result.Removed, err
+}
+
+// duplicateError is returned by the add methods when the torrent is already added
+type duplicateError struct {
+	torrent transmission.TorrentAdded
+}
+
+func (e *duplicateError) Error() string {
+	return fmt.Sprintf("%s is already added", e.torrent.Name)
+}
+
+// addTorrent executes torrent-add with the arguments, an already added torrent is returned with a duplicateError
+func (client transmissionClient) addTorrent(arguments map[string]interface{}) (transmission.TorrentAdded, error) {
+	var result struct {
+		Added     *transmission.TorrentAdded `json:"torrent-added"`
+		Duplicate *transmission.TorrentAdded `json:"torrent-duplicate"`
+	}
+	err := client.call("torrent-add", arguments, &result)
+	if err != nil {
+		return transmission.TorrentAdded{}, err
+	}
+	if result.Added != nil {
+		return *result.Added, nil
+	}
+	if result.Duplicate != nil {
+		return *result.Duplicate, &duplicateError{*result.Duplicate}
+	}
+	return transmission.TorrentAdded{}, nil
+}
+
+// addArguments describe a torrent to add
+type addArguments struct {
+	url string
+	// metainfo is the contents of the .torrent file, it's added instead of the URL if it's set
+	metainfo []byte
+	// dir is the download directory, the default one if it's empty
+	dir    string
+	paused bool
+}
+
+// AddTorrent adds a torrent with the arguments
+func (client transmissionClient) AddTorrent(a addArguments) (transmission.TorrentAdded, error) {
+	arguments := map[string]interface{}{"filename": a.url, "paused": a.paused}
+	if a.metainfo != nil {
+		delete(arguments, "filename")
+		arguments["metainfo"] = base64.StdEncoding.EncodeToString(a.metainfo)
+	}
+	if a.dir != "" {
+		arguments["download-dir"] = a.dir
+	}
+	return client.addTorrent(arguments)
+}
+
+// SetLocation moves the data of the torrent to the directory
+func (client transmissionClient) SetLocation(id int, dir string) error {
+	return client.call("torrent-set-location", map[string]interface{}{"ids": []int{id}, "location": dir, "move": true}, nil)
+}
+
+// FreeSpace returns the free space in the directory in bytes
+func (client transmissionClient) FreeSpace(path string) (uint64, error) {
+	var result struct {
+		Size uint64 `json:"size-bytes"`
+	}
+	err := client.call("free-space", map[string]string{"path": path}, &result)
+	return result.Size, err
 }
 
 func (client transmissionClient) DeleteTorrent(id int, wd bool) (string, error) {
@@ -11,8 +182,7 @@ func (client transmissionClient) DeleteTorrent(id int, wd bool) (string, error)
 }
 
 func (client transmissionClient) AddByURL(url string) (transmission.TorrentAdded, error) {
-	cmd := transmission.NewAddCmdByURL(url)
-	return client.client.ExecuteAddCommand(cmd)
+	return client.addTorrent(map[string]interface{}{"filename": url})
 }
 
 func (client transmissionClient) GetStats() (*transmission.Stats, error) {
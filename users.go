@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+// users lists all the users and their roles
+func users(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	roles, err := s.GetUserRoles()
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>users</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+
+	keys := make([]string, 0, len(roles))
+	for key := range roles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf := new(bytes.Buffer)
+	for _, key := range keys {
+		if strings.HasPrefix(key, "@") {
+			buf.WriteString(fmt.Sprintf("%s <i>%s</i> (hasn't talked to the bot yet)\n", escape(key), roles[key]))
+		} else {
+			buf.WriteString(fmt.Sprintf("<a href=\"tg://user?id=%s\">%s</a> <i>%s</i>\n", key, key, roles[key]))
+		}
+	}
+
+	if buf.Len() == 0 {
+		send(bot, "No users", ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, buf.String(), ud.Chat.ID, ud.Role())
+}
+
+// userCommand adds and removes users: user add <id|@name> <role>, user remove <id|@name>
+func userCommand(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	tokens := ud.Tokens()
+	if len(tokens) < 2 {
+		send(bot, "<b>user</b>: takes <code>add &lt;id|@name&gt; &lt;role&gt;</code> or <code>remove &lt;id|@name&gt;</code>", ud.Chat.ID, ud.Role())
+		return
+	}
+
+	key := userKey(tokens[1])
+	if id, err := s.GetUserID(strings.TrimPrefix(key, "@")); err == nil && id != 0 {
+		key = strconv.FormatInt(id, 10)
+	}
+
+	switch strings.ToLower(tokens[0]) {
+	case "add":
+		if len(tokens) < 3 {
+			send(bot, "<b>user add</b>: needs a role: viewer, operator or admin", ud.Chat.ID, ud.Role())
+			return
+		}
+		r, ok := parseRole(tokens[2])
+		if !ok {
+			send(bot, fmt.Sprintf("<b>user add</b>: unknown role <code>%s</code>", escape(tokens[2])), ud.Chat.ID, ud.Role())
+			return
+		}
+		last, err := lastAdmin(s, key)
+		if err == nil && last && r != roleAdmin {
+			send(bot, fmt.Sprintf("<b>user add</b>: <code>%s</code> is the last admin", escape(key)), ud.Chat.ID, ud.Role())
+			return
+		}
+		if err == nil {
+			err = s.SetUserRole(key, r.String())
+		}
+		if err != nil {
+			send(bot, fmt.Sprintf("<b>user add</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			return
+		}
+		send(bot, fmt.Sprintf("<b>user add</b>: <code>%s</code> is %s now", escape(key), r), ud.Chat.ID, ud.Role())
+	case "remove", "rm", "del":
+		if key == strconv.Itoa(ud.From.ID) {
+			send(bot, "<b>user remove</b>: you can't remove yourself", ud.Chat.ID, ud.Role())
+			return
+		}
+		role, err := s.GetUserRole(key)
+		if err == nil && role == "" {
+			send(bot, fmt.Sprintf("<b>user remove</b>: no user <code>%s</code>", escape(key)), ud.Chat.ID, ud.Role())
+			return
+		}
+		if err == nil {
+			err = s.DeleteUserRole(key)
+		}
+		if err != nil {
+			send(bot, fmt.Sprintf("<b>user remove</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+			return
+		}
+		send(bot, fmt.Sprintf("<b>user remove</b>: <code>%s</code> removed", escape(key)), ud.Chat.ID, ud.Role())
+	default:
+		send(bot, fmt.Sprintf("<b>user</b>: Unknown argument <code>%s</code>", escape(tokens[0])), ud.Chat.ID, ud.Role())
+	}
+}
+
+// lastAdmin checks whether the user is the only admin, who can't lose the role
+func lastAdmin(s settings.Settings, key string) (bool, error) {
+	roles, err := s.GetUserRoles()
+	if err != nil {
+		return false, err
+	}
+	if roles[key] != roleAdmin.String() {
+		return false, nil
+	}
+	for k, r := range roles {
+		if k != key && r == roleAdmin.String() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// invite generates a one-time code, a new user joins with the role by sending /start <code>
+func invite(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings) {
+	r := roleViewer
+	if len(ud.Tokens()) > 0 {
+		var ok bool
+		if r, ok = parseRole(ud.Tokens()[0]); !ok {
+			send(bot, fmt.Sprintf("<b>invite</b>: unknown role <code>%s</code>", escape(ud.Tokens()[0])), ud.Chat.ID, ud.Role())
+			return
+		}
+	}
+
+	code, err := randomHex(8)
+	if err == nil {
+		err = s.SetInvite(code, r.String())
+	}
+	if err != nil {
+		send(bot, fmt.Sprintf("<b>invite</b>: <code>%s</code>", escape(err.Error())), ud.Chat.ID, ud.Role())
+		return
+	}
+	send(bot, fmt.Sprintf("<b>invite</b>: a new %s should send <code>/start %s</code> to the bot, the code works once", r, code), ud.Chat.ID, ud.Role())
+}
+
+// redeemInvite lets a user without a role join by sending /start <code> in a private chat,
+// returns false if the message is not an invite
+func redeemInvite(bot telegramClient, ud messageWrapper, s settings.Settings) bool {
+	if ud.From == nil || !ud.Chat.IsPrivate() || len(ud.Tokens()) != 1 ||
+		(ud.Command() != "/start" && ud.Command() != "start") {
+		return false
+	}
+
+	name, err := s.TakeInvite(ud.Tokens()[0])
+	if err != nil {
+		log.Println("TakeInvite failed:", err.Error())
+		return false
+	}
+	r, ok := parseRole(name)
+	if !ok {
+		return false
+	}
+
+	if err := s.SetUserRole(strconv.Itoa(ud.From.ID), r.String()); err != nil {
+		log.Println("SetUserRole failed:", err.Error())
+		return false
+	}
+	if ud.From.UserName != "" {
+		s.SetUserID(strings.ToLower(ud.From.UserName), int64(ud.From.ID))
+	}
+	log.Printf("[INFO] %s joined as %s", ud.From.String(), r)
+
+	send(bot, fmt.Sprintf("Welcome! You are %s now, try /help", r), ud.Chat.ID, r)
+	return true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zhulik/transmission-telegram/settings"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// runUserCommand runs the command of the admin and returns the reply
+func runUserCommand(t *testing.T, s settings.Settings, text string) string {
+	ud := wrapMessage(testMessage(100, "master", &tgbotapi.Chat{ID: 100, Type: "private"}, text), "ourbot")
+	ud.role = roleAdmin
+	fake := &fakeTelegramClient{}
+	userCommand(fake, nil, ud, s)
+	texts := fake.texts()
+	if len(texts) != 1 {
+		t.Fatalf("Wrong replies to %s: %v", text, texts)
+	}
+	return texts[0]
+}
+
+func TestUserCommand(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	s.SetUserRole("100", "admin")
+	s.SetUserRole("200", "operator")
+	s.SetUserID("operator", 200)
+
+	if reply := runUserCommand(t, s, "user add 100 viewer"); !strings.Contains(reply, "last admin") {
+		t.Fatalf("The last admin is demoted: %s", reply)
+	}
+	if r, _ := s.GetUserRole("100"); r != "admin" {
+		t.Fatalf("The last admin has role %s", r)
+	}
+	if reply := runUserCommand(t, s, "user remove 100"); !strings.Contains(reply, "yourself") {
+		t.Fatalf("The admin removed themselves: %s", reply)
+	}
+
+	runUserCommand(t, s, "user add @Operator admin")
+	if r, _ := s.GetUserRole("200"); r != "admin" {
+		t.Fatalf("The known user is not promoted by username, the role is %s", r)
+	}
+	runUserCommand(t, s, "user add 100 viewer")
+	if r, _ := s.GetUserRole("100"); r != "viewer" {
+		t.Fatalf("The admin is not demoted while there is another one, the role is %s", r)
+	}
+
+	runUserCommand(t, s, "user add @newbie operator")
+	if r, _ := s.GetUserRole("@newbie"); r != "operator" {
+		t.Fatalf("The unknown user is not stored by username, the role is %s", r)
+	}
+	if reply := runUserCommand(t, s, "user add 300 owner"); !strings.Contains(reply, "unknown role") {
+		t.Fatalf("Unknown role is accepted: %s", reply)
+	}
+	if reply := runUserCommand(t, s, "user remove 400"); !strings.Contains(reply, "no user") {
+		t.Fatalf("Unknown user is removed: %s", reply)
+	}
+}
+
+func TestRedeemInvite(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	s.SetInvite("code", "operator")
+
+	group := wrapMessage(testMessage(300, "Joiner", &tgbotapi.Chat{ID: -42, Type: "group"}, "/start code"), "ourbot")
+	fake := &fakeTelegramClient{}
+	if redeemInvite(fake, group, s) {
+		t.Fatal("Invite is redeemed in a group")
+	}
+
+	private := wrapMessage(testMessage(300, "Joiner", &tgbotapi.Chat{ID: 300, Type: "private"}, "/start code"), "ourbot")
+	if !redeemInvite(fake, private, s) {
+		t.Fatal("Invite is not redeemed")
+	}
+	if r, _ := s.GetUserRole("300"); r != "operator" {
+		t.Fatalf("Wrong role %s", r)
+	}
+	if id, _ := s.GetUserID("joiner"); id != 300 {
+		t.Fatal("Username is not stored")
+	}
+	if texts := fake.texts(); len(texts) != 1 || !strings.Contains(texts[0], "operator") {
+		t.Fatalf("Wrong welcome: %v", texts)
+	}
+
+	s.DeleteUserRole("300")
+	if redeemInvite(fake, private, s) {
+		t.Fatal("Invite is redeemed twice")
+	}
+	wrong := wrapMessage(testMessage(400, "", &tgbotapi.Chat{ID: 400, Type: "private"}, "/start wrong"), "ourbot")
+	if redeemInvite(fake, wrong, s) {
+		t.Fatal("Unknown code is redeemed")
+	}
+}
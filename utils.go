@@ -42,6 +42,11 @@ var (
 	}
 )
 
+const (
+	// maxChunks is the maximum number of messages a text can be split into, longer texts are sent as documents
+	maxChunks = 5
+)
+
 type sortMethod struct {
 	name     string
 	reversed bool
@@ -49,15 +54,30 @@ type sortMethod struct {
 
 type messageWrapper struct {
 	*tgbotapi.Message
-	command string
-	tokens  []string
+	command       string
+	tokens        []string
+	forAnotherBot bool
+	role          role
 }
 
-func wrapMessage(message *tgbotapi.Message) messageWrapper {
+// wrapMessage parses the command of a message, commands like /list@botname are
+// addressed to the bot only when the mention is the bot's username
+func wrapMessage(message *tgbotapi.Message, botName string) messageWrapper {
 	tokens := strings.Split(message.Text, " ")
 	command := strings.ToLower(tokens[0])
 	args := tokens[1:]
-	return messageWrapper{message, command, args}
+
+	var forAnotherBot bool
+	if i := strings.Index(command, "@"); i > 0 {
+		forAnotherBot = !strings.EqualFold(command[i+1:], botName)
+		command = command[:i]
+	}
+	return messageWrapper{message, command, args, forAnotherBot, roleNone}
+}
+
+// ForAnotherBot is true when the command mentions some other bot
+func (w messageWrapper) ForAnotherBot() bool {
+	return w.forAnotherBot
 }
 
 func (w messageWrapper) Command() string {
@@ -68,6 +88,11 @@ func (w messageWrapper) Tokens() []string {
 	return w.tokens
 }
 
+// Role is the role of the message sender
+func (w messageWrapper) Role() role {
+	return w.role
+}
+
 type commandHandler func(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings)
 type torrentFilter func(torrent *transmission.Torrent) bool
 
@@ -78,12 +103,13 @@ func ellipsisString(str string, length int) string {
 	return str
 }
 
-// send takes a chat id and a message to send, returns the message id of the send message
-func send(bot telegramClient, text string, chatID int64, addKeyboard bool) int {
+// send takes a chat id and a message to send, returns the message id of the send message.
+// The commands keyboard of the role is attached, messages sent with roleNone keep the keyboard the chat has
+func send(bot telegramClient, text string, chatID int64, keyboardRole role) int {
 	var keyboard interface{}
 
-	if addKeyboard {
-		keyboard = commandsKeyboard()
+	if keyboardRole != roleNone {
+		keyboard = commandsKeyboard(keyboardRole)
 	}
 	return sendWithKeyboard(bot, text, chatID, keyboard)
 }
@@ -92,15 +118,32 @@ func sendWithKeyboard(bot telegramClient, text string, chatID int64, keyboard in
 	// set typing action
 	action := tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping)
 	bot.Send(action)
-	lastMessageID := 0
 
-	for _, chunk := range splitStringToChunks(text) {
-		// if msgRuneCount < 4096, send it normally
+	if k, ok := keyboard.(*tgbotapi.InlineKeyboardMarkup); ok && k == nil {
+		keyboard = nil
+	}
+
+	chunks := splitMessage(text, messageLimit)
+	if len(chunks) > maxChunks {
+		return sendAsDocument(bot, text, chatID, keyboard)
+	}
+
+	lastMessageID := 0
+	for i, chunk := range chunks {
 		msg := tgbotapi.NewMessage(chatID, chunk)
 		msg.DisableWebPagePreview = true
-		msg.ParseMode = tgbotapi.ModeMarkdown
-		msg.ReplyMarkup = keyboard
+		msg.ParseMode = tgbotapi.ModeHTML
+		// the keyboard is attached only to the last chunk
+		if i == len(chunks)-1 {
+			msg.ReplyMarkup = keyboard
+		}
 		resp, err := bot.Send(msg)
+		if isParseError(err) {
+			// fallback to the plain text, the message is still useful without formatting
+			msg.Text = plainText(chunk)
+			msg.ParseMode = ""
+			resp, err = bot.Send(msg)
+		}
 		if err != nil {
 			log.Printf("[ERROR] Send: %s", err)
 		}
@@ -109,44 +152,62 @@ func sendWithKeyboard(bot telegramClient, text string, chatID int64, keyboard in
 	return lastMessageID
 }
 
-func splitStringToChunks(text string) []string {
-	sub := ""
-	subs := []string{}
-
-	runes := bytes.Runes([]byte(text))
-	l := len(runes)
-	for i, r := range runes {
-		sub = sub + string(r)
-		if (i+1)%4096 == 0 {
-			subs = append(subs, sub)
-			sub = ""
-		} else if (i + 1) == l {
-			subs = append(subs, sub)
-		}
+// sendAsDocument sends a text too long for a few messages as a text file
+func sendAsDocument(bot telegramClient, text string, chatID int64, keyboard interface{}) int {
+	doc := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileBytes{Name: "message.txt", Bytes: []byte(plainText(text))})
+	doc.ReplyMarkup = keyboard
+	resp, err := bot.Send(doc)
+	if err != nil {
+		log.Printf("[ERROR] Send: %s", err)
 	}
+	return resp.MessageID
+}
 
-	return subs
+// edit replaces the text of previously sent message
+func edit(bot telegramClient, text string, chatID int64, msgID int, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	editConf := tgbotapi.NewEditMessageText(chatID, msgID, text)
+	editConf.ParseMode = tgbotapi.ModeHTML
+	editConf.DisableWebPagePreview = true
+	editConf.ReplyMarkup = keyboard
+	_, err := bot.Send(editConf)
+	if isParseError(err) {
+		editConf.Text = plainText(text)
+		editConf.ParseMode = ""
+		_, err = bot.Send(editConf)
+	}
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		return nil
+	}
+	if err != nil {
+		log.Printf("[ERROR] Edit: %s", err)
+	}
+	return err
 }
 
-func sendTorrents(bot telegramClient, ud messageWrapper, torrents transmission.Torrents) {
+// sendTorrents sends the list of the torrents, the owners are shown if they are known
+func sendTorrents(bot telegramClient, ud messageWrapper, torrents transmission.Torrents, owners map[int]settings.TorrentOwner) {
 	buf := new(bytes.Buffer)
 	for _, torrent := range torrents {
-		name := ellipsisString(mdEscape(torrent.Name), 25)
-		buf.WriteString(fmt.Sprintf("*%d* `%s` _%s_\n", torrent.ID, name, torrent.TorrentStatus()))
+		name := escape(ellipsisString(torrent.Name, 25))
+		buf.WriteString(fmt.Sprintf("<b>%d</b> <code>%s</code> <i>%s</i>", torrent.ID, name, torrent.TorrentStatus()))
+		if owner, ok := owners[torrent.ID]; ok {
+			buf.WriteString(" " + escape(ownerName(owner)))
+		}
+		buf.WriteString("\n")
 	}
 
 	if buf.Len() == 0 {
-		send(bot, "No torrents", ud.Message.Chat.ID, true)
+		send(bot, "No torrents", ud.Message.Chat.ID, ud.Role())
 		return
 	}
 
-	send(bot, buf.String(), ud.Message.Chat.ID, true)
+	send(bot, buf.String(), ud.Message.Chat.ID, ud.Role())
 }
 
-func sendFilteredTorrets(bot telegramClient, client torrentClient, ud messageWrapper, filter torrentFilter) {
+func sendFilteredTorrets(bot telegramClient, client torrentClient, ud messageWrapper, s settings.Settings, filter torrentFilter) {
 	torrents, err := client.GetTorrents()
 	if err != nil {
-		send(bot, "Torrents obtain error: "+err.Error(), ud.Message.Chat.ID, true)
+		send(bot, "Torrents obtain error: "+escape(err.Error()), ud.Message.Chat.ID, ud.Role())
 		return
 	}
 
@@ -156,7 +217,11 @@ func sendFilteredTorrets(bot telegramClient, client torrentClient, ud messageWra
 			filteredTorrents = append(filteredTorrents, torrent)
 		}
 	}
-	sendTorrents(bot, ud, filteredTorrents)
+	owners, err := torrentOwners(client, s)
+	if err != nil {
+		log.Println("Getting torrent owners failed:", err.Error())
+	}
+	sendTorrents(bot, ud, filteredTorrents, owners)
 }
 
 func invokeError(any interface{}, name string, args ...interface{}) error {
@@ -193,5 +258,5 @@ func progressString(persentage float64, length int) string {
 }
 
 func progressBar(t *transmission.Torrent) string {
-	return fmt.Sprintf("%s %.1f%% %s ↓%s", progressString(t.PercentDone, 10), t.PercentDone, t.ETA(), humanize.Bytes(t.RateDownload))
+	return fmt.Sprintf("%s %.1f%% %s ↓%s", progressString(t.PercentDone, 10), t.PercentDone*100, t.ETA(), humanize.Bytes(t.RateDownload))
 }
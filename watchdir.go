@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pyed/transmission"
+	"github.com/zhulik/transmission-telegram/settings"
+)
+
+const (
+	watchInterval = 5 * time.Second
+	// files modified recently could be still written
+	watchSettleTime = 2 * time.Second
+	watchDoneDir    = "done"
+	watchFailedDir  = "failed"
+)
+
+// dirWatcher adds .torrent and .magnet files dropped into the directory for the user, processed files
+// are moved to the done or failed subdirectory and notify is called with each result
+type dirWatcher struct {
+	dir    string
+	userID int
+	notify func(text string)
+
+	// readErr is the last error reading the directory, it's logged once
+	readErr string
+	// unmovable are the processed files that can't be moved, they aren't added again
+	unmovable map[string]bool
+}
+
+// watchDir scans the directory until the bot stops, the files are added for the user
+// or for the first admin if it's 0 and the admins are notified
+func watchDir(bot telegramClient, client torrentClient, auth *authorizer, s settings.Settings, dir string, userID int) {
+	w := &dirWatcher{dir: dir, userID: userID, notify: func(text string) {
+		for _, id := range auth.admins() {
+			send(bot, text, int64(id), roleNone)
+		}
+	}}
+	for {
+		if userID == 0 {
+			if admins := auth.admins(); len(admins) > 0 {
+				w.userID = admins[0]
+			}
+		}
+		w.scan(client, s, time.Now())
+		time.Sleep(watchInterval)
+	}
+}
+
+// scan processes the files of the directory, it stops while transmission can't be reached
+func (w *dirWatcher) scan(client torrentClient, s settings.Settings, now time.Time) {
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		if err.Error() != w.readErr {
+			log.Printf("[ERROR] Watch directory: %s", err)
+			w.readErr = err.Error()
+		}
+		return
+	}
+	if w.readErr != "" {
+		log.Printf("[INFO] Watch directory %s can be read again", w.dir)
+		w.readErr = ""
+	}
+
+	unmovable := make(map[string]bool)
+	for _, file := range files {
+		ext := strings.ToLower(filepath.Ext(file.Name()))
+		if file.IsDir() || ext != ".torrent" && ext != ".magnet" || now.Sub(file.ModTime()) < watchSettleTime {
+			continue
+		}
+		path := filepath.Join(w.dir, file.Name())
+		if w.unmovable[path] {
+			unmovable[path] = true
+			continue
+		}
+
+		name := escape(file.Name())
+		torrent, err := w.addFile(client, s, path, ext)
+		if isConnectionError(err) {
+			// the files are left in place to be added on the next scan
+			log.Printf("[ERROR] Watch directory: %s", err)
+			break
+		}
+		target := watchDoneDir
+		if err != nil {
+			target = watchFailedDir
+			w.notify(fmt.Sprintf("<b>watch</b>: <code>%s</code>: %s", name, describeAddError(client, err)))
+		} else {
+			w.notify(fmt.Sprintf("<b>watch</b>: <code>%s</code> is added as <b>%d</b> <code>%s</code>", name, torrent.ID, escape(torrent.Name)))
+		}
+
+		if err := moveWatchedFile(path, filepath.Join(w.dir, target)); err != nil {
+			log.Printf("[ERROR] Watch directory: %s", err)
+			unmovable[path] = true
+			w.notify(fmt.Sprintf("<b>watch</b>: <code>%s</code> can't be moved to %s, it's left in place: <code>%s</code>",
+				name, target, escape(err.Error())))
+		}
+	}
+	// the files removed from the directory are forgotten
+	w.unmovable = unmovable
+}
+
+func (w *dirWatcher) addFile(client torrentClient, s settings.Settings, path string, ext string) (transmission.TorrentAdded, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return transmission.TorrentAdded{}, err
+	}
+
+	a := addArguments{metainfo: data}
+	if ext == ".magnet" {
+		link := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(link, "magnet:") {
+			return transmission.TorrentAdded{}, fmt.Errorf("no magnet link in the file")
+		}
+		a = addArguments{url: link}
+	}
+	return addForUser(client, s, w.userID, "", a, func(text string) {
+		w.notify(fmt.Sprintf("<b>watch</b>: <code>%s</code>: %s", escape(filepath.Base(path)), text))
+	})
+}
+
+// moveWatchedFile moves the file to the directory, a file with the same name gets a timestamp
+func moveWatchedFile(path string, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	target := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(target); err == nil {
+		target = filepath.Join(dir, fmt.Sprintf("%s-%s", time.Now().Format("20060102150405"), filepath.Base(path)))
+	}
+	return os.Rename(path, target)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyed/transmission"
+)
+
+// watchClient describes the duplicates from the torrents
+type watchClient struct {
+	addClient
+}
+
+func (c *watchClient) GetTorrent(id int) (*transmission.Torrent, error) {
+	for _, t := range c.torrents {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no torrent %d", id)
+}
+
+func TestScanWatchDir(t *testing.T) {
+	s, cleanup := testSettings(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const existing = "magnet:?xt=urn:btih:875a2d90068c32b4ce7992eaf56cd03f5be0d193&dn=show&xl=500"
+	files := map[string]string{
+		"a.torrent":     "metainfo",
+		"b.magnet":      " magnet:?xt=urn:btih:aaa\n",
+		"c.torrent":     "broken",
+		"d.magnet":      "http://example.org",
+		"e.magnet":      existing,
+		"notes.txt":     "text",
+		"new.torrent":   "new",
+		"done/e.magnet": "magnet:?xt=urn:btih:eee",
+	}
+	old := time.Now().Add(-time.Minute)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if name != "new.torrent" {
+			os.Chtimes(path, old, old)
+		}
+	}
+
+	// the files are added in the order of their names
+	client := &watchClient{addClient{fakeTorrentClient: fakeTorrentClient{
+		torrents: transmission.Torrents{{ID: 7, Name: "show", SizeWhenDone: 500}}, hashes: map[int]string{}},
+		errs: []error{nil, nil, fmt.Errorf("invalid or corrupt torrent file")}}}
+	var messages []string
+	w := &dirWatcher{dir: dir, userID: 1, notify: func(text string) {
+		messages = append(messages, text)
+	}}
+	w.scan(client, s, time.Now())
+	if len(messages) != 5 || !strings.Contains(messages[4], "<b>7</b> <code>show</code> is already added") {
+		t.Errorf("Wrong messages: %v", messages)
+	}
+	if len(client.added) != 2 || string(client.added[0].metainfo) != "metainfo" || client.added[1].url != "magnet:?xt=urn:btih:aaa" {
+		t.Errorf("Wrong torrents are added: %v", client.added)
+	}
+	if owner, err := s.GetTorrentOwner("torrent 1"); err != nil || owner == nil || owner.UserID != 1 {
+		t.Errorf("Wrong owner: %v, %v", owner, err)
+	}
+
+	for _, path := range []string{"done/a.torrent", "done/b.magnet", "failed/c.torrent", "failed/d.magnet", "failed/e.magnet",
+		"notes.txt", "new.torrent"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("%s is missing: %s", path, err)
+		}
+	}
+
+	// transmission can't be reached, the file is added on the next scan
+	messages = nil
+	path := filepath.Join(dir, "f.torrent")
+	if err := ioutil.WriteFile(path, []byte("later"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, old, old)
+	client.errs = []error{&url.Error{Op: "Post", URL: "http://localhost:9091/transmission/rpc", Err: fmt.Errorf("connection refused")}}
+	w.scan(client, s, time.Now())
+	if len(messages) != 0 {
+		t.Fatalf("Wrong messages: %v", messages)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("The file is moved: %s", err)
+	}
+	w.scan(client, s, time.Now())
+	if _, err := os.Stat(filepath.Join(dir, "done/f.torrent")); err != nil {
+		t.Errorf("The file is not added again: %s", err)
+	}
+
+	// the file can't be moved, it's reported once and not added again
+	messages = nil
+	path = filepath.Join(dir, "g.torrent")
+	if err := ioutil.WriteFile(path, []byte("stuck"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, old, old)
+	if err := os.RemoveAll(filepath.Join(dir, "done")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "done"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	added := len(client.added)
+	w.scan(client, s, time.Now())
+	w.scan(client, s, time.Now())
+	if len(client.added) != added+1 || len(messages) != 2 || !strings.Contains(messages[1], "can't be moved") {
+		t.Errorf("Wrong unmovable file handling: %d added, %v", len(client.added)-added, messages)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("The file is moved: %s", err)
+	}
+}
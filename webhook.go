@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+type webhookConfig struct {
+	URL      string
+	Listen   string
+	CertFile string
+	KeyFile  string
+	Secret   string
+	// Proxy serves plain HTTP to a proxy terminating TLS
+	Proxy bool
+}
+
+// listenForWebhook registers the webhook in telegram and starts an HTTPS server receiving updates,
+// or an HTTP one behind a proxy. The error of the server is sent to the returned channel once it stops
+func listenForWebhook(bot *tgbotapi.BotAPI, conf webhookConfig) (tgbotapi.UpdatesChannel, <-chan error, error) {
+	hookURL, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if conf.Secret == "" {
+		conf.Secret, err = randomHex(32)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if conf.Proxy {
+		if conf.CertFile != "" || conf.KeyFile != "" {
+			return nil, nil, fmt.Errorf("the proxy serves the certificate, -cert and -key are not used with it")
+		}
+		err = setWebhook(bot, hookURL, conf.Secret, nil)
+	} else {
+		tlsConfig, err = webhookTLS(bot, hookURL, conf)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan tgbotapi.Update, bot.Buffer)
+	path := hookURL.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, webhookHandler(conf.Secret, ch))
+
+	server := &http.Server{
+		Addr:      conf.Listen,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		if conf.Proxy {
+			log.Printf("[INFO] Listening for webhook on %s behind a proxy", conf.Listen)
+			errs <- server.ListenAndServe()
+			return
+		}
+		log.Printf("[INFO] Listening for webhook on %s", conf.Listen)
+		errs <- server.ListenAndServeTLS("", "")
+	}()
+
+	return ch, errs, nil
+}
+
+// webhookTLS registers the webhook with the configured or generated certificate and returns the TLS config serving it
+func webhookTLS(bot *tgbotapi.BotAPI, hookURL *url.URL, conf webhookConfig) (*tls.Config, error) {
+	certPEM, keyPEM, err := webhookCertificate(conf, hookURL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// self-signed certificates are uploaded to telegram
+	selfSigned := bytes.Equal(leaf.RawIssuer, leaf.RawSubject) &&
+		leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature) == nil
+	if !selfSigned {
+		certPEM = nil
+	}
+	if err := setWebhook(bot, hookURL, conf.Secret, certPEM); err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// webhookHandler accepts updates only with the right secret token
+func webhookHandler(secret string, ch chan<- tgbotapi.Update) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(secret)) != 1 {
+			log.Printf("[INFO] Webhook request with wrong secret from %s", r.RemoteAddr)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var update tgbotapi.Update
+		if err := json.Unmarshal(body, &update); err != nil {
+			log.Printf("[ERROR] Webhook: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ch <- update
+	}
+}
+
+// setWebhook registers the webhook, the certificate is uploaded to telegram unless it's nil
+func setWebhook(bot *tgbotapi.BotAPI, hookURL *url.URL, secret string, certPEM []byte) error {
+	if certPEM == nil {
+		v := url.Values{}
+		v.Add("url", hookURL.String())
+		v.Add("secret_token", secret)
+		_, err := bot.MakeRequest("setWebhook", v)
+		return err
+	}
+
+	params := map[string]string{
+		"url":          hookURL.String(),
+		"secret_token": secret,
+	}
+	_, err := bot.UploadFile("setWebhook", params, "certificate", tgbotapi.FileBytes{Name: "cert.pem", Bytes: certPEM})
+	return err
+}
+
+// webhookCertificate loads the configured certificate or generates a self-signed one
+func webhookCertificate(conf webhookConfig, host string) ([]byte, []byte, error) {
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		if conf.CertFile == "" || conf.KeyFile == "" {
+			return nil, nil, fmt.Errorf("both certificate and key are required")
+		}
+		certPEM, err := ioutil.ReadFile(conf.CertFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyPEM, err := ioutil.ReadFile(conf.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return certPEM, keyPEM, nil
+	}
+	log.Printf("[INFO] Generating self-signed certificate for %s", host)
+	return selfSignedCertificate(host)
+}
+
+func selfSignedCertificate(host string) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	// clients check the host against the subject alternative names, not the common name
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+// randomHex returns n random bytes encoded as a hex string
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
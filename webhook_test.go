@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+func TestWebhookHandlerSecret(t *testing.T) {
+	ch := make(chan tgbotapi.Update, 1)
+	handler := webhookHandler("secret", ch)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"update_id": 1}`))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(`{"update_id": 1}`))
+	req.Header.Set(secretHeader, "secret")
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if update := <-ch; update.UpdateID != 1 {
+		t.Fatal("Wrong update received")
+	}
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	for _, host := range []string{"example.org", "192.0.2.1"} {
+		certPEM, keyPEM, err := selfSignedCertificate(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(certPEM), "CERTIFICATE") || !strings.Contains(string(keyPEM), "PRIVATE KEY") {
+			t.Fatal("Wrong PEM generated")
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Errorf("%s: %s", host, err)
+		}
+	}
+}